@@ -11,6 +11,9 @@ import (
 //go:generate protoc -Ibundled/ --include_imports -ogen/google_protobuf_timestamp.fdp bundled/google/protobuf/timestamp.proto
 //go:generate protoc -Ibundled/ --include_imports -ogen/google_protobuf_duration.fdp bundled/google/protobuf/duration.proto
 //go:generate protoc -Ibundled/ --include_imports -ogen/google_protobuf_struct.fdp bundled/google/protobuf/struct.proto
+//go:generate protoc -Ibundled/ --include_imports -ogen/google_protobuf_any.fdp bundled/google/protobuf/any.proto
+//go:generate protoc -Ibundled/ --include_imports -ogen/google_protobuf_field_mask.fdp bundled/google/protobuf/field_mask.proto
+//go:generate protoc -Ibundled/ --include_imports -ogen/google_protobuf_wrappers.fdp bundled/google/protobuf/wrappers.proto
 //go:generate protoc -Ibundled/ --include_imports -ogen/protoc-gen-openapiv2_options_annotations.fdp bundled/protoc-gen-openapiv2/options/annotations.proto
 //go:generate protoc -Ibundled/ --include_imports -ogen/xo_xo.fdp bundled/xo/xo.proto
 // Assets contains gen project assets.
@@ -18,6 +21,13 @@ import (
 //go:embed gen/*
 var Assets embed.FS
 
+// Opt contains the Gunk annotations of github.com/gunk/opt which Gunk supports,
+// but which the released versions of the module don't declare yet. The loader
+// adds them to the module's packages, as opt/<package>/<file>.gunk.
+//
+//go:embed opt
+var Opt embed.FS
+
 // ReadFile returns a file from the assets.
 func ReadFile(name string) ([]byte, error) {
 	return Assets.ReadFile(path.Join("gen", name))
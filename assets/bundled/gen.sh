@@ -4,7 +4,7 @@ SRC=$(realpath $(cd -P "$(dirname "${BASH_SOURCE[0]}")" && pwd))
 
 # grab google protobuf definitions
 mkdir -p $SRC/google/protobuf
-for i in descriptor duration empty timestamp struct; do
+for i in descriptor duration empty timestamp struct any field_mask wrappers; do
   wget -O $SRC/google/protobuf/$i.proto https://raw.githubusercontent.com/protocolbuffers/protobuf/main/src/google/protobuf/$i.proto
 done
 
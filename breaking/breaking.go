@@ -0,0 +1,158 @@
+// Package breaking implements gunk breaking, which reports the changes to a
+// Gunk package that break compatibility with a baseline version of it.
+package breaking
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/generate"
+	"github.com/gunk/gunk/log"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Baseline is the version of a Gunk package to compare it against. Exactly
+// one of its fields must be set.
+type Baseline struct {
+	// File is the path of a FileDescriptorSet written by gunk dump, in
+	// either format.
+	File string
+	// GitRef is a git ref of the repository the package is in. The package
+	// is loaded from the tree at the ref, with the same patterns.
+	GitRef string
+}
+
+// Run compares the Gunk package matching patterns in dir against the
+// baseline, and writes the changes breaking at level to w, one per line. An
+// error is returned if there are any.
+func Run(w io.Writer, dir string, logger *log.Logger, base Baseline, level Level, patterns ...string) error {
+	var baseSet *descriptorpb.FileDescriptorSet
+	var err error
+	switch {
+	case base.File != "" && base.GitRef != "":
+		return fmt.Errorf("cannot compare against both a file and a git ref")
+	case base.File != "":
+		baseSet, err = readFileDescriptorSet(base.File)
+	case base.GitRef != "":
+		baseSet, err = gitFileDescriptorSet(logger, dir, base.GitRef, patterns...)
+	default:
+		return fmt.Errorf("no baseline to compare against")
+	}
+	if err != nil {
+		return err
+	}
+	curSet, err := generate.FileDescriptorSet(dir, patterns...)
+	if err != nil {
+		return err
+	}
+	changes := Compare(baseSet, curSet, level)
+	for _, c := range changes {
+		fmt.Fprintln(w, c)
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("found %d breaking changes at level %s", len(changes), level)
+	}
+	return nil
+}
+
+// readFileDescriptorSet reads a FileDescriptorSet written by gunk dump, as
+// JSON if it looks like it, or as binary protobuf otherwise.
+func readFileDescriptorSet(path string) (*descriptorpb.FileDescriptorSet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fds descriptorpb.FileDescriptorSet
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		err = json.Unmarshal(data, &fds)
+	} else {
+		err = proto.Unmarshal(data, &fds)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read baseline %s: %w", path, err)
+	}
+	return &fds, nil
+}
+
+// gitFileDescriptorSet loads the Gunk package matching patterns in dir from
+// the tree at ref, written to a temporary directory.
+func gitFileDescriptorSet(logger *log.Logger, dir, ref string, patterns ...string) (*descriptorpb.FileDescriptorSet, error) {
+	if dir == "" {
+		dir = "."
+	}
+	cmd := logger.CommandContext(context.Background(), "git", "rev-parse", "--show-toplevel", "--show-prefix")
+	cmd.Dir = dir
+	out, err := logger.Output(cmd)
+	if err != nil {
+		return nil, log.ExecError("git rev-parse", err)
+	}
+	lines := strings.SplitN(strings.TrimSuffix(string(out), "\n"), "\n", 2)
+	if len(lines) != 2 {
+		return nil, fmt.Errorf("unexpected git rev-parse output: %q", out)
+	}
+	top, prefix := lines[0], lines[1]
+	tmpDir, err := ioutil.TempDir("", "gunk-breaking")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	cmd = logger.CommandContext(context.Background(), "git", "archive", "--format=tar", ref)
+	cmd.Dir = top
+	out, err = logger.Output(cmd)
+	if err != nil {
+		return nil, log.ExecError("git archive", err)
+	}
+	if err := extractTar(tmpDir, bytes.NewReader(out)); err != nil {
+		return nil, fmt.Errorf("unable to check out %s: %w", ref, err)
+	}
+	fds, err := generate.FileDescriptorSet(filepath.Join(tmpDir, filepath.FromSlash(prefix)), patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load baseline at %s: %w", ref, err)
+	}
+	return fds, nil
+}
+
+// extractTar writes the directories, files and symlinks of a tar archive to
+// dir.
+func extractTar(dir string, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(dir, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0o755)
+		case tar.TypeReg:
+			var data []byte
+			if data, err = ioutil.ReadAll(tr); err == nil {
+				err = os.MkdirAll(filepath.Dir(path), 0o755)
+			}
+			if err == nil {
+				err = ioutil.WriteFile(path, data, os.FileMode(hdr.Mode)&0o777)
+			}
+		case tar.TypeSymlink:
+			err = os.Symlink(hdr.Linkname, path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package breaking
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Level is how strict the compatibility checks are. Each level reports the
+// changes of the levels below it too.
+type Level int
+
+const (
+	// Wire reports the changes which break the binary encoding.
+	Wire Level = iota
+	// WireJSON also reports the changes which break the JSON encoding,
+	// such as renamed fields and enum values.
+	WireJSON
+	// Source also reports the changes which break the code generated from
+	// the packages, such as removed messages.
+	Source
+)
+
+var levelNames = []string{"WIRE", "WIRE_JSON", "SOURCE"}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the name, which is one of WIRE,
+// WIRE_JSON or SOURCE.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown level %q: use %s", name, strings.Join(levelNames, ", "))
+}
+
+// Change is a breaking change of a proto element.
+type Change struct {
+	// File is the name of the proto file declaring the element.
+	File string
+	// Element is the full name of the changed message, enum or service.
+	Element string
+	// Level is the lowest level the change is reported at.
+	Level   Level
+	Message string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %s: %s", c.File, c.Element, c.Message)
+}
+
+// comparer accumulates the changes found at a level.
+type comparer struct {
+	level   Level
+	file    string
+	changes []Change
+}
+
+func (c *comparer) add(level Level, elem, format string, args ...interface{}) {
+	if level > c.level {
+		return
+	}
+	c.changes = append(c.changes, Change{
+		File:    c.file,
+		Element: elem,
+		Level:   level,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Compare returns the changes from the proto files in base to the ones in cur
+// which are breaking at level, in the order of the files and elements in
+// base.
+func Compare(base, cur *descriptorpb.FileDescriptorSet, level Level) []Change {
+	curFiles := make(map[string]*descriptorpb.FileDescriptorProto)
+	for _, f := range cur.GetFile() {
+		curFiles[f.GetName()] = f
+	}
+	c := &comparer{level: level}
+	for _, bf := range base.GetFile() {
+		c.file = bf.GetName()
+		cf := curFiles[bf.GetName()]
+		if cf == nil {
+			c.add(Source, bf.GetPackage(), "file was removed")
+			continue
+		}
+		c.compareFile(bf, cf)
+	}
+	return c.changes
+}
+
+func (c *comparer) compareFile(base, cur *descriptorpb.FileDescriptorProto) {
+	prefix := base.GetPackage()
+	if base.GetPackage() != cur.GetPackage() {
+		c.add(Wire, prefix, "package changed to %s", cur.GetPackage())
+		return
+	}
+	c.compareMessages(prefix, base.GetMessageType(), cur.GetMessageType())
+	c.compareEnums(prefix, base.GetEnumType(), cur.GetEnumType())
+	curSvcs := make(map[string]*descriptorpb.ServiceDescriptorProto)
+	for _, s := range cur.GetService() {
+		curSvcs[s.GetName()] = s
+	}
+	for _, bs := range base.GetService() {
+		name := fullName(prefix, bs.GetName())
+		cs := curSvcs[bs.GetName()]
+		if cs == nil {
+			c.add(Wire, name, "service was removed")
+			continue
+		}
+		c.compareService(name, bs, cs)
+	}
+}
+
+func (c *comparer) compareMessages(prefix string, base, cur []*descriptorpb.DescriptorProto) {
+	curMsgs := make(map[string]*descriptorpb.DescriptorProto)
+	for _, m := range cur {
+		curMsgs[m.GetName()] = m
+	}
+	for _, bm := range base {
+		name := fullName(prefix, bm.GetName())
+		cm := curMsgs[bm.GetName()]
+		if cm == nil {
+			c.add(Source, name, "message was removed")
+			continue
+		}
+		c.compareMessage(name, bm, cm)
+	}
+}
+
+func (c *comparer) compareMessage(name string, base, cur *descriptorpb.DescriptorProto) {
+	byNumber := make(map[int32]*descriptorpb.FieldDescriptorProto)
+	byName := make(map[string]*descriptorpb.FieldDescriptorProto)
+	for _, f := range cur.GetField() {
+		byNumber[f.GetNumber()] = f
+		byName[f.GetName()] = f
+	}
+	for _, bf := range base.GetField() {
+		if cf := byName[bf.GetName()]; cf != nil && cf.GetNumber() != bf.GetNumber() {
+			c.add(Wire, name, "field %q changed number from %d to %d", bf.GetName(), bf.GetNumber(), cf.GetNumber())
+			continue
+		}
+		cf := byNumber[bf.GetNumber()]
+		if cf == nil {
+			switch {
+			case !fieldNumberReserved(cur, bf.GetNumber()):
+				c.add(Wire, name, "field %q (%d) was removed without reserving its number", bf.GetName(), bf.GetNumber())
+			case !containsString(cur.GetReservedName(), bf.GetName()):
+				c.add(WireJSON, name, "field %q (%d) was removed without reserving its name", bf.GetName(), bf.GetNumber())
+			default:
+				c.add(Source, name, "field %q (%d) was removed", bf.GetName(), bf.GetNumber())
+			}
+			continue
+		}
+		c.compareField(name, bf, cf)
+	}
+	c.compareMessages(name, base.GetNestedType(), cur.GetNestedType())
+	c.compareEnums(name, base.GetEnumType(), cur.GetEnumType())
+}
+
+func (c *comparer) compareField(msg string, base, cur *descriptorpb.FieldDescriptorProto) {
+	if bt, ct := fieldType(base), fieldType(cur); bt != ct {
+		c.add(Wire, msg, "field %q (%d) changed type from %s to %s", base.GetName(), base.GetNumber(), bt, ct)
+	}
+	if bl, cl := fieldLabel(base), fieldLabel(cur); bl != cl {
+		c.add(Wire, msg, "field %q (%d) changed label from %s to %s", base.GetName(), base.GetNumber(), bl, cl)
+	}
+	if bj, cj := fieldJSONName(base), fieldJSONName(cur); bj != cj {
+		c.add(WireJSON, msg, "field %q (%d) changed JSON name from %q to %q", base.GetName(), base.GetNumber(), bj, cj)
+	}
+	if base.GetName() != cur.GetName() {
+		c.add(Source, msg, "field %d was renamed from %q to %q", base.GetNumber(), base.GetName(), cur.GetName())
+	}
+}
+
+func (c *comparer) compareEnums(prefix string, base, cur []*descriptorpb.EnumDescriptorProto) {
+	curEnums := make(map[string]*descriptorpb.EnumDescriptorProto)
+	for _, e := range cur {
+		curEnums[e.GetName()] = e
+	}
+	for _, be := range base {
+		name := fullName(prefix, be.GetName())
+		ce := curEnums[be.GetName()]
+		if ce == nil {
+			c.add(Source, name, "enum was removed")
+			continue
+		}
+		c.compareEnum(name, be, ce)
+	}
+}
+
+func (c *comparer) compareEnum(name string, base, cur *descriptorpb.EnumDescriptorProto) {
+	// Several values may have the same number if allow_alias is set.
+	byNumber := make(map[int32][]string)
+	for _, v := range cur.GetValue() {
+		byNumber[v.GetNumber()] = append(byNumber[v.GetNumber()], v.GetName())
+	}
+	for _, bv := range base.GetValue() {
+		names := byNumber[bv.GetNumber()]
+		switch {
+		case len(names) == 0 && !enumNumberReserved(cur, bv.GetNumber()):
+			c.add(Wire, name, "enum value %q (%d) was removed without reserving its number", bv.GetName(), bv.GetNumber())
+		case len(names) == 0 && !containsString(cur.GetReservedName(), bv.GetName()):
+			c.add(WireJSON, name, "enum value %q (%d) was removed without reserving its name", bv.GetName(), bv.GetNumber())
+		case len(names) == 0:
+			c.add(Source, name, "enum value %q (%d) was removed", bv.GetName(), bv.GetNumber())
+		case !containsString(names, bv.GetName()):
+			c.add(WireJSON, name, "enum value %d was renamed from %q to %q", bv.GetNumber(), bv.GetName(), names[0])
+		}
+	}
+}
+
+func (c *comparer) compareService(name string, base, cur *descriptorpb.ServiceDescriptorProto) {
+	curMethods := make(map[string]*descriptorpb.MethodDescriptorProto)
+	for _, m := range cur.GetMethod() {
+		curMethods[m.GetName()] = m
+	}
+	for _, bm := range base.GetMethod() {
+		cm := curMethods[bm.GetName()]
+		if cm == nil {
+			c.add(Wire, name, "method %q was removed", bm.GetName())
+			continue
+		}
+		if bt, ct := bm.GetInputType(), cm.GetInputType(); bt != ct {
+			c.add(Wire, name, "method %q changed request type from %s to %s", bm.GetName(), typeName(bt), typeName(ct))
+		}
+		if bt, ct := bm.GetOutputType(), cm.GetOutputType(); bt != ct {
+			c.add(Wire, name, "method %q changed response type from %s to %s", bm.GetName(), typeName(bt), typeName(ct))
+		}
+		if bs, cs := bm.GetClientStreaming(), cm.GetClientStreaming(); bs != cs {
+			c.add(Wire, name, "method %q changed client streaming from %t to %t", bm.GetName(), bs, cs)
+		}
+		if bs, cs := bm.GetServerStreaming(), cm.GetServerStreaming(); bs != cs {
+			c.add(Wire, name, "method %q changed server streaming from %t to %t", bm.GetName(), bs, cs)
+		}
+	}
+}
+
+func fullName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+// typeName returns a fully qualified type name without its leading dot.
+func typeName(name string) string {
+	return strings.TrimPrefix(name, ".")
+}
+
+// fieldType returns the type of a field as written in proto files.
+func fieldType(f *descriptorpb.FieldDescriptorProto) string {
+	switch f.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptorpb.FieldDescriptorProto_TYPE_ENUM,
+		descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return typeName(f.GetTypeName())
+	}
+	return strings.ToLower(strings.TrimPrefix(f.GetType().String(), "TYPE_"))
+}
+
+func fieldLabel(f *descriptorpb.FieldDescriptorProto) string {
+	switch f.GetLabel() {
+	case descriptorpb.FieldDescriptorProto_LABEL_REPEATED:
+		return "repeated"
+	case descriptorpb.FieldDescriptorProto_LABEL_REQUIRED:
+		return "required"
+	}
+	return "singular"
+}
+
+// fieldJSONName returns the JSON name of a field, which defaults to its name
+// in lower camel case.
+func fieldJSONName(f *descriptorpb.FieldDescriptorProto) string {
+	if f.JsonName != nil {
+		return f.GetJsonName()
+	}
+	var b strings.Builder
+	upper := false
+	for _, r := range f.GetName() {
+		switch {
+		case r == '_':
+			upper = true
+		case upper && 'a' <= r && r <= 'z':
+			b.WriteRune(r - 'a' + 'A')
+			upper = false
+		default:
+			b.WriteRune(r)
+			upper = false
+		}
+	}
+	return b.String()
+}
+
+func fieldNumberReserved(m *descriptorpb.DescriptorProto, n int32) bool {
+	for _, r := range m.GetReservedRange() {
+		// The end of message ranges is exclusive.
+		if r.GetStart() <= n && n < r.GetEnd() {
+			return true
+		}
+	}
+	return false
+}
+
+func enumNumberReserved(e *descriptorpb.EnumDescriptorProto, n int32) bool {
+	for _, r := range e.GetReservedRange() {
+		// The end of enum ranges is inclusive.
+		if r.GetStart() <= n && n <= r.GetEnd() {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(slice []string, str string) bool {
+	for _, s := range slice {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -8,6 +9,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"text/template"
 
 	"github.com/kenshaw/ini"
 	"github.com/kenshaw/ini/parser"
@@ -34,8 +36,12 @@ type Generator struct {
 	Out           string
 	JSONPostProc  bool
 	FixPaths      bool
-	Shortened     bool // only for `gunk vet`
-	Single        bool
+	// Postproc is a command to pipe each generated file through, as a
+	// template with the path of the file as File and the name of the
+	// package as Package.
+	Postproc  string
+	Shortened bool // only for `gunk vet`
+	Single    bool
 }
 
 func (g Generator) IsProtoc() bool {
@@ -54,7 +60,7 @@ func (g Generator) HasPostproc() bool {
 		// for gofumpt
 		return true
 	}
-	return g.JSONPostProc || g.FixPaths
+	return g.JSONPostProc || g.FixPaths || g.Postproc != ""
 }
 
 func (g Generator) GetParam(key string) (string, bool) {
@@ -86,6 +92,7 @@ type Config struct {
 	ProtocVersion string
 	Generators    []Generator
 	Format        FormatConfig
+	Lint          LintConfig
 }
 
 // FormatConfig is configuration for the format command.
@@ -98,6 +105,20 @@ type FormatConfig struct {
 	Initialisms []string
 }
 
+// LintConfig is configuration for the lint command.
+type LintConfig struct {
+	// Linters to run. If empty, all linters are run.
+	Enable []string
+	// Linters not to run, even if they are in Enable.
+	Disable []string
+	// Settings of each linter by name, set with keys of the form
+	// "linter.setting".
+	Settings map[string]map[string]string
+}
+
+// ErrNotFound is returned by Load when there is no .gunkconfig.
+var ErrNotFound = errors.New("no .gunkconfig found")
+
 // Load will attempt to find the .gunkconfig in the 'dir', working
 // its way up to each parent looking for a .gunkconfig. Currently,
 // Load will only stop when it is unable to go any further up the
@@ -162,7 +183,7 @@ func Load(dir string) (*Config, error) {
 	}
 	// If no configs were found, return an error.
 	if len(cfgs) == 0 {
-		return nil, fmt.Errorf("no .gunkconfig found for %q", dir)
+		return nil, fmt.Errorf("%w for %q", ErrNotFound, dir)
 	}
 	// Merge the found configs.
 	// TODO(hhhapz): merge Format config.
@@ -178,6 +199,20 @@ func Load(dir string) (*Config, error) {
 		if protocPath := c.ProtocPath; config.ProtocPath == "" {
 			config.ProtocPath = protocPath
 		}
+		// The lint settings closest to the package take precedence too.
+		if len(config.Lint.Enable) == 0 {
+			config.Lint.Enable = c.Lint.Enable
+		}
+		if len(config.Lint.Disable) == 0 {
+			config.Lint.Disable = c.Lint.Disable
+		}
+		for linter, settings := range c.Lint.Settings {
+			for k, v := range settings {
+				if _, ok := config.Lint.Settings[linter][k]; !ok {
+					config.Lint.setting(linter, k, v)
+				}
+			}
+		}
 		// Don't create duplicated generate single generators.
 		for _, g := range c.Generators {
 			if g.Single {
@@ -225,6 +260,8 @@ func LoadSingle(reader io.Reader, dir string) (*Config, error) {
 			gen, err = handleGenerate(config, s, nil)
 		case name == "format":
 			err = handleFormat(config, s)
+		case name == "lint":
+			err = handleLint(config, s)
 		case strings.HasPrefix(name, "generate "):
 			// Check to see if we have the shorten version of a generate config:
 			// [generate js].
@@ -319,6 +356,18 @@ func handleGenerate(config *Config, section *parser.Section, shorthand *string)
 				return nil, fmt.Errorf("cannot parse json_tag_postproc: %w", err)
 			}
 			gen.JSONPostProc = p
+		case "postproc":
+			if strings.TrimSpace(v) == "" {
+				return nil, fmt.Errorf("postproc command cannot be empty")
+			}
+			// The command is split on spaces before executing each
+			// argument as a template, so templates can't have spaces.
+			for _, field := range strings.Fields(v) {
+				if _, err := template.New("postproc").Parse(field); err != nil {
+					return nil, fmt.Errorf("cannot parse postproc: %w", err)
+				}
+			}
+			gen.Postproc = replacePATH(v, config.Dir)
 		case "generate_single":
 			single, err := strconv.ParseBool(v)
 			if err != nil {
@@ -392,6 +441,47 @@ func handleFormat(config *Config, section *parser.Section) error {
 	return nil
 }
 
+func handleLint(config *Config, section *parser.Section) error {
+	for _, k := range section.RawKeys() {
+		v := strings.TrimSpace(section.GetRaw(k))
+		switch k {
+		case "enable":
+			config.Lint.Enable = splitList(v)
+		case "disable":
+			config.Lint.Disable = splitList(v)
+		default:
+			linter, setting, ok := strings.Cut(k, ".")
+			if !ok || linter == "" || setting == "" {
+				return fmt.Errorf("unexpected key %q in lint section", k)
+			}
+			config.Lint.setting(linter, setting, v)
+		}
+	}
+	return nil
+}
+
+// setting sets a setting of a linter.
+func (c *LintConfig) setting(linter, setting, value string) {
+	if c.Settings == nil {
+		c.Settings = make(map[string]map[string]string)
+	}
+	if c.Settings[linter] == nil {
+		c.Settings[linter] = make(map[string]string)
+	}
+	c.Settings[linter][setting] = value
+}
+
+// splitList splits a comma-separated list, ignoring empty elements.
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 // replacePATH processes the provided value, and replaces $PATH with the config
 // directory.
 func replacePATH(value string, path string) string {
@@ -10,7 +10,6 @@ import (
 
 	"github.com/gunk/gunk/config"
 	"github.com/gunk/gunk/format"
-	"github.com/gunk/gunk/generate/downloader"
 	"github.com/gunk/gunk/loader"
 )
 
@@ -35,20 +34,14 @@ func run(path string, overwrite bool) error {
 	// Look for a .gunkconfig
 	absPath, _ := filepath.Abs(path)
 	cfg, err := config.Load(filepath.Dir(absPath))
-	var cfgProtocPath, cfgProtocVer, importPath string
+	var importPath string
 	if err == nil {
 		importPath = filepath.Join(cfg.Dir, cfg.ImportPath)
-		cfgProtocPath = cfg.ProtocPath
-		cfgProtocVer = cfg.ProtocVersion
-	}
-	protocPath, err := downloader.CheckOrDownloadProtoc(cfgProtocPath, cfgProtocVer)
-	if err != nil {
-		return err
 	}
 	// Determine whether the path is a file or a directory.
 	// If it is a file convert the file.
 	if !fi.IsDir() {
-		return convertFile(path, overwrite, importPath, protocPath)
+		return convertFile(path, overwrite, importPath)
 	}
 	// If the path is a directory and has a .proto extension then error.
 	if filepath.Ext(path) == ".proto" {
@@ -66,7 +59,7 @@ func run(path string, overwrite bool) error {
 		if f.IsDir() || filepath.Ext(f.Name()) != ".proto" {
 			continue
 		}
-		if err := convertFile(filepath.Join(path, f.Name()), overwrite, importPath, protocPath); err != nil {
+		if err := convertFile(filepath.Join(path, f.Name()), overwrite, importPath); err != nil {
 			return err
 		}
 	}
@@ -75,7 +68,7 @@ func run(path string, overwrite bool) error {
 
 // convertFile reads the provided .proto file and writes a corresponding .gunk
 // file in the same directory.
-func convertFile(path string, overwrite bool, importPath string, protocPath string) error {
+func convertFile(path string, overwrite bool, importPath string) error {
 	if filepath.Ext(path) != ".proto" {
 		return fmt.Errorf("convert requires a .proto file")
 	}
@@ -91,7 +84,7 @@ func convertFile(path string, overwrite bool, importPath string, protocPath stri
 		return fmt.Errorf("path already exists %q, use --overwrite", fullpath)
 	}
 	var b bytes.Buffer
-	if err := loader.ConvertFromProto(&b, file, filename, importPath, protocPath); err != nil {
+	if err := loader.ConvertFromProto(&b, file, filename, importPath); err != nil {
 		return err
 	}
 	result, err := format.Source(b.Bytes())
@@ -0,0 +1,335 @@
+// Package diagnostic writes the errors found in Gunk packages and
+// configuration files in formats read by other tools, such as SARIF for
+// GitHub code scanning.
+package diagnostic
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"go/scanner"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// The formats of diagnostics.
+const (
+	FormatText       = "text"
+	FormatJSON       = "json"
+	FormatSARIF      = "sarif"
+	FormatCheckstyle = "checkstyle"
+)
+
+// Severity is how serious a diagnostic is.
+type Severity string
+
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+)
+
+// Diagnostic is an error or a warning in a file.
+type Diagnostic struct {
+	// Rule identifies the check which found the diagnostic, such as the
+	// name of a linter.
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	// File is the path of the file. The position fields are zero if
+	// unknown.
+	File      string `json:"file,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Column    int    `json:"column,omitempty"`
+	EndLine   int    `json:"endLine,omitempty"`
+	EndColumn int    `json:"endColumn,omitempty"`
+	Message   string `json:"message"`
+}
+
+// FromError returns the diagnostic of an error in a scanner.ErrorList, such
+// as the ones of gunk lint.
+func FromError(rule string, severity Severity, err *scanner.Error) Diagnostic {
+	return Diagnostic{
+		Rule:     rule,
+		Severity: severity,
+		File:     err.Pos.Filename,
+		Line:     err.Pos.Line,
+		Column:   err.Pos.Column,
+		Message:  err.Msg,
+	}
+}
+
+// FromPackageError returns the diagnostic of an error loading a package, whose
+// position is of the form "file:line:column", "file:line" or "file".
+func FromPackageError(rule string, err packages.Error) Diagnostic {
+	d := Diagnostic{Rule: rule, Severity: Error, Message: err.Msg}
+	if err.Pos == "" || err.Pos == "-" {
+		return d
+	}
+	// Parse the numbers from the end, as the file name may have colons.
+	pos := err.Pos
+	var nums []int
+	for len(nums) < 2 {
+		i := strings.LastIndexByte(pos, ':')
+		if i < 0 {
+			break
+		}
+		n, err := strconv.Atoi(pos[i+1:])
+		if err != nil {
+			break
+		}
+		nums = append([]int{n}, nums...)
+		pos = pos[:i]
+	}
+	d.File = pos
+	if len(nums) > 0 {
+		d.Line = nums[0]
+	}
+	if len(nums) > 1 {
+		d.Column = nums[1]
+	}
+	return d
+}
+
+// String returns the diagnostic in the text format, like a scanner.Error.
+func (d Diagnostic) String() string {
+	switch {
+	case d.File == "":
+		return d.Message
+	case d.Line == 0:
+		return fmt.Sprintf("%s: %s", d.File, d.Message)
+	case d.Column == 0:
+		return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+// Printer accumulates diagnostics, and writes them all at once in a format
+// with Flush. A nil Printer accumulates nothing, and the commands taking one
+// print their diagnostics as text instead.
+type Printer struct {
+	w      io.Writer
+	format string
+	diags  []Diagnostic
+}
+
+// New returns a Printer writing diagnostics to w in the format, or nil if the
+// format is text.
+func New(w io.Writer, format string) (*Printer, error) {
+	switch format {
+	case FormatText:
+		return nil, nil
+	case FormatJSON, FormatSARIF, FormatCheckstyle:
+		return &Printer{w: w, format: format}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q: use text, json, sarif or checkstyle", format)
+}
+
+// Add adds diagnostics to be written by Flush.
+func (p *Printer) Add(diags ...Diagnostic) {
+	p.diags = append(p.diags, diags...)
+}
+
+// Flush writes the diagnostics added so far, even if there are none.
+func (p *Printer) Flush() error {
+	if p == nil {
+		return nil
+	}
+	var err error
+	switch p.format {
+	case FormatJSON:
+		err = writeJSON(p.w, p.diags)
+	case FormatSARIF:
+		err = writeSARIF(p.w, p.diags)
+	case FormatCheckstyle:
+		err = writeCheckstyle(p.w, p.diags)
+	}
+	p.diags = nil
+	return err
+}
+
+func writeJSON(w io.Writer, diags []Diagnostic) error {
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diags)
+}
+
+// The subset of SARIF 2.1.0 needed for diagnostics. See
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html.
+type (
+	sarifLog struct {
+		Version string     `json:"version"`
+		Schema  string     `json:"$schema"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID string `json:"id"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     Severity        `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           *sarifRegion          `json:"region,omitempty"`
+	}
+	sarifArtifactLocation struct {
+		URI string `json:"uri"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn,omitempty"`
+		EndLine     int `json:"endLine,omitempty"`
+		EndColumn   int `json:"endColumn,omitempty"`
+	}
+)
+
+func writeSARIF(w io.Writer, diags []Diagnostic) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "gunk",
+			InformationURI: "https://github.com/gunk/gunk",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	var rules []string
+	for _, d := range diags {
+		if !containsString(rules, d.Rule) {
+			rules = append(rules, d.Rule)
+		}
+		res := sarifResult{
+			RuleID:  d.Rule,
+			Level:   d.Severity,
+			Message: sarifMessage{Text: d.Message},
+		}
+		if d.File != "" {
+			loc := sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: fileURI(d.File)},
+			}}
+			if d.Line > 0 {
+				loc.PhysicalLocation.Region = &sarifRegion{
+					StartLine:   d.Line,
+					StartColumn: d.Column,
+					EndLine:     d.EndLine,
+					EndColumn:   d.EndColumn,
+				}
+			}
+			res.Locations = []sarifLocation{loc}
+		}
+		run.Results = append(run.Results, res)
+	}
+	sort.Strings(rules)
+	for _, r := range rules {
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifRule{ID: r})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	})
+}
+
+// fileURI returns the URI of a file for SARIF, which is relative to the
+// current directory if the file is in it, so that code scanning can match it
+// to the files of the repository.
+func fileURI(path string) string {
+	if wd, err := os.Getwd(); err == nil && filepath.IsAbs(path) {
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	if filepath.IsAbs(path) {
+		return "file://" + filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(path)
+}
+
+// The checkstyle XML format, read by many CI tools.
+type (
+	checkstyleResult struct {
+		XMLName xml.Name         `xml:"checkstyle"`
+		Version string           `xml:"version,attr"`
+		Files   []checkstyleFile `xml:"file"`
+	}
+	checkstyleFile struct {
+		Name   string            `xml:"name,attr"`
+		Errors []checkstyleError `xml:"error"`
+	}
+	checkstyleError struct {
+		Line     int      `xml:"line,attr"`
+		Column   int      `xml:"column,attr,omitempty"`
+		Severity Severity `xml:"severity,attr"`
+		Message  string   `xml:"message,attr"`
+		Source   string   `xml:"source,attr"`
+	}
+)
+
+func writeCheckstyle(w io.Writer, diags []Diagnostic) error {
+	res := checkstyleResult{Version: "4.3"}
+	// Group the diagnostics by file, in the order they were added.
+	files := make(map[string]int)
+	for _, d := range diags {
+		i, ok := files[d.File]
+		if !ok {
+			i = len(res.Files)
+			files[d.File] = i
+			res.Files = append(res.Files, checkstyleFile{Name: d.File})
+		}
+		res.Files[i].Errors = append(res.Files[i].Errors, checkstyleError{
+			Line:     d.Line,
+			Column:   d.Column,
+			Severity: d.Severity,
+			Message:  d.Message,
+			Source:   "gunk." + d.Rule,
+		})
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(res); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func containsString(slice []string, str string) bool {
+	for _, s := range slice {
+		if s == str {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gunk/gunk/generate"
+)
+
+// Run translates the specified Gunk packages and writes them as proto source
+// files. If outDir is empty, the proto file is written to stdout, which is
+// only possible for a single package. Otherwise, each proto file is written
+// to outDir, under the path it is imported as by the other proto files.
+func Run(dir, outDir string, patterns ...string) error {
+	files, deps, err := generate.ProtoFiles(dir, patterns...)
+	if err != nil {
+		return err
+	}
+	if outDir == "" {
+		if len(files) != 1 {
+			return fmt.Errorf("can only export a single Gunk package to stdout, use --output to export %d packages", len(files))
+		}
+		return WriteProto(os.Stdout, files[0], deps...)
+	}
+	// The exported files may depend on each other.
+	deps = append(deps, files...)
+	for _, file := range files {
+		var buf bytes.Buffer
+		if err := WriteProto(&buf, file, deps...); err != nil {
+			return err
+		}
+		path := filepath.Join(outDir, filepath.FromSlash(file.GetName()))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,794 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// Paths of the descriptor fields in SourceCodeInfo locations, as defined in
+// descriptor.proto.
+const (
+	packagePath       = 2 // FileDescriptorProto.Package
+	messagePath       = 4 // FileDescriptorProto.MessageType
+	enumPath          = 5 // FileDescriptorProto.EnumType
+	servicePath       = 6 // FileDescriptorProto.Service
+	messageFieldPath  = 2 // DescriptorProto.Field
+	messageNestedPath = 3 // DescriptorProto.NestedType
+	messageEnumPath   = 4 // DescriptorProto.EnumType
+	messageOneofPath  = 8 // DescriptorProto.OneofDecl
+	enumValuePath     = 2 // EnumDescriptorProto.Value
+	serviceMethodPath = 2 // ServiceDescriptorProto.Method
+)
+
+const (
+	maxFieldNumber = 536870911
+	maxEnumNumber  = math.MaxInt32
+)
+
+// WriteProto writes a proto file as proto source, including its comments and
+// options. deps are the proto files loaded along with file, used to find the
+// files declaring the extensions set in its options.
+func WriteProto(w io.Writer, file *descriptorpb.FileDescriptorProto, deps ...*descriptorpb.FileDescriptorProto) error {
+	p := &printer{
+		file:       file,
+		locs:       make(map[string]*descriptorpb.SourceCodeInfo_Location),
+		extensions: make(map[string]string),
+	}
+	for _, dep := range deps {
+		for _, ext := range dep.Extension {
+			p.extensions[dep.GetPackage()+"."+ext.GetName()] = dep.GetName()
+		}
+		// Extensions can also be declared in messages.
+		var addNested func(prefix string, msgs []*descriptorpb.DescriptorProto)
+		addNested = func(prefix string, msgs []*descriptorpb.DescriptorProto) {
+			for _, msg := range msgs {
+				name := prefix + "." + msg.GetName()
+				for _, ext := range msg.Extension {
+					p.extensions[name+"."+ext.GetName()] = dep.GetName()
+				}
+				addNested(name, msg.NestedType)
+			}
+		}
+		addNested(dep.GetPackage(), dep.MessageType)
+	}
+	for _, loc := range file.GetSourceCodeInfo().GetLocation() {
+		p.locs[pathKey(loc.Path)] = loc
+	}
+	if err := p.printFile(); err != nil {
+		return err
+	}
+	_, err := w.Write(p.buf.Bytes())
+	return err
+}
+
+// printer writes the proto source of a file.
+type printer struct {
+	buf    bytes.Buffer
+	file   *descriptorpb.FileDescriptorProto
+	locs   map[string]*descriptorpb.SourceCodeInfo_Location
+	indent int
+	// Maps full extension names to the loaded files declaring them.
+	extensions map[string]string
+}
+
+// line writes a single line of source at the current indentation.
+func (p *printer) line(format string, args ...interface{}) {
+	if format == "" {
+		p.buf.WriteByte('\n')
+		return
+	}
+	p.buf.WriteString(strings.Repeat("  ", p.indent))
+	fmt.Fprintf(&p.buf, format, args...)
+	p.buf.WriteByte('\n')
+}
+
+// comments writes the detached and leading comments of the element at path.
+func (p *printer) comments(path []int32) {
+	loc := p.locs[pathKey(path)]
+	if loc == nil {
+		return
+	}
+	for _, detached := range loc.LeadingDetachedComments {
+		p.comment(detached)
+		p.line("")
+	}
+	if loc.LeadingComments != nil {
+		p.comment(loc.GetLeadingComments())
+	}
+}
+
+// trailingComment writes the trailing comment of the element at path.
+func (p *printer) trailingComment(path []int32) {
+	if loc := p.locs[pathKey(path)]; loc.GetTrailingComments() != "" {
+		p.comment(loc.GetTrailingComments())
+	}
+}
+
+// comment writes a comment's text as line comments.
+func (p *printer) comment(text string) {
+	text = strings.TrimSuffix(text, "\n")
+	for _, line := range strings.Split(text, "\n") {
+		p.line("//%s", strings.TrimRight(line, " \t"))
+	}
+}
+
+func (p *printer) printFile() error {
+	f := p.file
+	syntax := f.GetSyntax()
+	if syntax == "" {
+		syntax = "proto2"
+	}
+	p.line("syntax = %s;", quote(syntax))
+	if f.Package != nil {
+		p.line("")
+		p.comments([]int32{packagePath})
+		p.line("package %s;", f.GetPackage())
+	}
+	// Options may use extensions from files Gunk doesn't import, as
+	// generators only need them to be registered.
+	var missing []string
+	for _, name := range p.extensionFiles() {
+		if !containsString(f.Dependency, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(f.Dependency)+len(missing) > 0 {
+		p.line("")
+		for _, name := range missing {
+			p.line("import %s;", quote(name))
+		}
+		for i, dep := range f.Dependency {
+			switch {
+			case containsInt32(f.PublicDependency, int32(i)):
+				p.line("import public %s;", quote(dep))
+			case containsInt32(f.WeakDependency, int32(i)):
+				p.line("import weak %s;", quote(dep))
+			default:
+				p.line("import %s;", quote(dep))
+			}
+		}
+	}
+	if p.hasOptions(f.Options) {
+		p.line("")
+		if err := p.options(f.Options); err != nil {
+			return err
+		}
+	}
+	for i, msg := range f.MessageType {
+		p.line("")
+		if err := p.message(msg, []int32{messagePath, int32(i)}); err != nil {
+			return err
+		}
+	}
+	for i, enum := range f.EnumType {
+		p.line("")
+		if err := p.enum(enum, []int32{enumPath, int32(i)}); err != nil {
+			return err
+		}
+	}
+	for i, srv := range f.Service {
+		p.line("")
+		if err := p.service(srv, []int32{servicePath, int32(i)}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extensionFiles returns the sorted paths of the files declaring the
+// extensions set in the file's options. The files loaded by Gunk are preferred,
+// as the compiled-in extensions may have been registered under other paths.
+func (p *printer) extensionFiles() []string {
+	seen := make(map[string]bool)
+	add := func(opts proto.Message) {
+		if opts == nil || !opts.ProtoReflect().IsValid() {
+			return
+		}
+		for _, fd := range optionFields(opts.ProtoReflect()) {
+			if !fd.IsExtension() {
+				continue
+			}
+			if name, ok := p.extensions[string(fd.FullName())]; ok {
+				seen[name] = true
+			} else {
+				seen[fd.ParentFile().Path()] = true
+			}
+		}
+	}
+	var addMessage func(msg *descriptorpb.DescriptorProto)
+	addEnum := func(enum *descriptorpb.EnumDescriptorProto) {
+		add(enum.Options)
+		for _, val := range enum.Value {
+			add(val.Options)
+		}
+	}
+	addMessage = func(msg *descriptorpb.DescriptorProto) {
+		add(msg.Options)
+		for _, field := range msg.Field {
+			add(field.Options)
+		}
+		for _, oneof := range msg.OneofDecl {
+			add(oneof.Options)
+		}
+		for _, nested := range msg.NestedType {
+			addMessage(nested)
+		}
+		for _, enum := range msg.EnumType {
+			addEnum(enum)
+		}
+	}
+	add(p.file.Options)
+	for _, msg := range p.file.MessageType {
+		addMessage(msg)
+	}
+	for _, enum := range p.file.EnumType {
+		addEnum(enum)
+	}
+	for _, srv := range p.file.Service {
+		add(srv.Options)
+		for _, method := range srv.Method {
+			add(method.Options)
+		}
+	}
+	var files []string
+	for name := range seen {
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func (p *printer) message(msg *descriptorpb.DescriptorProto, path []int32) error {
+	p.comments(path)
+	p.line("message %s {", msg.GetName())
+	p.indent++
+	p.trailingComment(path)
+	if err := p.options(msg.Options); err != nil {
+		return err
+	}
+	var ranges []string
+	for _, r := range msg.ReservedRange {
+		// Message reserved ranges have an exclusive end.
+		ranges = append(ranges, reservedRange(r.GetStart(), r.GetEnd()-1, maxFieldNumber))
+	}
+	p.reserved(ranges, msg.ReservedName)
+	printedOneofs := make(map[int32]bool)
+	for i, field := range msg.Field {
+		if field.OneofIndex != nil && !field.GetProto3Optional() {
+			idx := field.GetOneofIndex()
+			if printedOneofs[idx] {
+				continue
+			}
+			printedOneofs[idx] = true
+			if err := p.oneof(msg, idx, path); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := p.field(msg, field, subPath(path, messageFieldPath, int32(i))); err != nil {
+			return err
+		}
+	}
+	for i, nested := range msg.NestedType {
+		if nested.GetOptions().GetMapEntry() {
+			continue
+		}
+		p.line("")
+		if err := p.message(nested, subPath(path, messageNestedPath, int32(i))); err != nil {
+			return err
+		}
+	}
+	for i, enum := range msg.EnumType {
+		p.line("")
+		if err := p.enum(enum, subPath(path, messageEnumPath, int32(i))); err != nil {
+			return err
+		}
+	}
+	p.indent--
+	p.line("}")
+	return nil
+}
+
+// oneof writes a oneof declaration, along with all of its fields.
+func (p *printer) oneof(msg *descriptorpb.DescriptorProto, idx int32, msgPath []int32) error {
+	path := subPath(msgPath, messageOneofPath, idx)
+	p.comments(path)
+	p.line("oneof %s {", msg.OneofDecl[idx].GetName())
+	p.indent++
+	p.trailingComment(path)
+	if err := p.options(msg.OneofDecl[idx].Options); err != nil {
+		return err
+	}
+	for i, field := range msg.Field {
+		if field.OneofIndex == nil || field.GetOneofIndex() != idx {
+			continue
+		}
+		if err := p.field(msg, field, subPath(msgPath, messageFieldPath, int32(i))); err != nil {
+			return err
+		}
+	}
+	p.indent--
+	p.line("}")
+	return nil
+}
+
+func (p *printer) field(msg *descriptorpb.DescriptorProto, field *descriptorpb.FieldDescriptorProto, path []int32) error {
+	p.comments(path)
+	var label string
+	switch {
+	case field.GetProto3Optional():
+		label = "optional "
+	case field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REPEATED:
+		label = "repeated "
+	case field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_REQUIRED:
+		label = "required "
+	case field.GetLabel() == descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL && p.file.GetSyntax() != "proto3" && field.OneofIndex == nil:
+		label = "optional "
+	}
+	typ := p.fieldType(field)
+	if entry := mapEntry(msg, field); entry != nil {
+		label = ""
+		typ = fmt.Sprintf("map<%s, %s>", p.fieldType(entry.Field[0]), p.fieldType(entry.Field[1]))
+	}
+	var opts []string
+	if field.JsonName != nil && field.GetJsonName() != jsonName(field.GetName()) {
+		opts = append(opts, "json_name = "+quote(field.GetJsonName()))
+	}
+	fieldOpts, err := p.inlineOptions(field.Options)
+	if err != nil {
+		return err
+	}
+	opts = append(opts, fieldOpts...)
+	decl := fmt.Sprintf("%s%s %s = %d", label, typ, field.GetName(), field.GetNumber())
+	if len(opts) > 0 {
+		decl += " [" + strings.Join(opts, ", ") + "]"
+	}
+	p.line("%s;", decl)
+	p.trailingComment(path)
+	return nil
+}
+
+// fieldType returns the type of a field, as written in proto source.
+func (p *printer) fieldType(field *descriptorpb.FieldDescriptorProto) string {
+	switch field.GetType() {
+	case descriptorpb.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptorpb.FieldDescriptorProto_TYPE_ENUM,
+		descriptorpb.FieldDescriptorProto_TYPE_GROUP:
+		return p.typeName(field.GetTypeName())
+	}
+	return strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_"))
+}
+
+// typeName returns a fully qualified type name relative to the file's
+// package.
+func (p *printer) typeName(name string) string {
+	if pkg := p.file.GetPackage(); pkg != "" && strings.HasPrefix(name, "."+pkg+".") {
+		return strings.TrimPrefix(name, "."+pkg+".")
+	}
+	return strings.TrimPrefix(name, ".")
+}
+
+// mapEntry returns the map entry message of a map field, or nil if the field
+// isn't a map.
+func mapEntry(msg *descriptorpb.DescriptorProto, field *descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+	if field.GetLabel() != descriptorpb.FieldDescriptorProto_LABEL_REPEATED ||
+		field.GetType() != descriptorpb.FieldDescriptorProto_TYPE_MESSAGE {
+		return nil
+	}
+	typeName := field.GetTypeName()
+	for _, nested := range msg.NestedType {
+		if nested.GetOptions().GetMapEntry() && strings.HasSuffix(typeName, "."+msg.GetName()+"."+nested.GetName()) && len(nested.Field) == 2 {
+			return nested
+		}
+	}
+	return nil
+}
+
+func (p *printer) enum(enum *descriptorpb.EnumDescriptorProto, path []int32) error {
+	p.comments(path)
+	p.line("enum %s {", enum.GetName())
+	p.indent++
+	p.trailingComment(path)
+	if err := p.options(enum.Options); err != nil {
+		return err
+	}
+	var ranges []string
+	for _, r := range enum.ReservedRange {
+		// Enum reserved ranges have an inclusive end.
+		ranges = append(ranges, reservedRange(r.GetStart(), r.GetEnd(), maxEnumNumber))
+	}
+	p.reserved(ranges, enum.ReservedName)
+	for i, val := range enum.Value {
+		valPath := subPath(path, enumValuePath, int32(i))
+		p.comments(valPath)
+		opts, err := p.inlineOptions(val.Options)
+		if err != nil {
+			return err
+		}
+		decl := fmt.Sprintf("%s = %d", val.GetName(), val.GetNumber())
+		if len(opts) > 0 {
+			decl += " [" + strings.Join(opts, ", ") + "]"
+		}
+		p.line("%s;", decl)
+		p.trailingComment(valPath)
+	}
+	p.indent--
+	p.line("}")
+	return nil
+}
+
+func (p *printer) service(srv *descriptorpb.ServiceDescriptorProto, path []int32) error {
+	p.comments(path)
+	p.line("service %s {", srv.GetName())
+	p.indent++
+	p.trailingComment(path)
+	if err := p.options(srv.Options); err != nil {
+		return err
+	}
+	for i, method := range srv.Method {
+		methodPath := subPath(path, serviceMethodPath, int32(i))
+		p.comments(methodPath)
+		input, output := p.typeName(method.GetInputType()), p.typeName(method.GetOutputType())
+		if method.GetClientStreaming() {
+			input = "stream " + input
+		}
+		if method.GetServerStreaming() {
+			output = "stream " + output
+		}
+		decl := fmt.Sprintf("rpc %s(%s) returns (%s)", method.GetName(), input, output)
+		if !p.hasOptions(method.Options) {
+			p.line("%s;", decl)
+			p.trailingComment(methodPath)
+			continue
+		}
+		p.line("%s {", decl)
+		p.indent++
+		p.trailingComment(methodPath)
+		if err := p.options(method.Options); err != nil {
+			return err
+		}
+		p.indent--
+		p.line("}")
+	}
+	p.indent--
+	p.line("}")
+	return nil
+}
+
+// reserved writes the reserved statements of a message or enum.
+func (p *printer) reserved(ranges, names []string) {
+	if len(ranges) > 0 {
+		p.line("reserved %s;", strings.Join(ranges, ", "))
+	}
+	if len(names) > 0 {
+		quoted := make([]string, len(names))
+		for i, name := range names {
+			quoted[i] = quote(name)
+		}
+		p.line("reserved %s;", strings.Join(quoted, ", "))
+	}
+}
+
+// reservedRange formats an inclusive range of reserved numbers.
+func reservedRange(start, end int32, max int32) string {
+	switch {
+	case start == end:
+		return strconv.Itoa(int(start))
+	case end == max:
+		return fmt.Sprintf("%d to max", start)
+	}
+	return fmt.Sprintf("%d to %d", start, end)
+}
+
+// optionFields returns the fields set in an options message, sorted by
+// number. Fields only set to their default value are omitted, as Gunk sets
+// them explicitly.
+func optionFields(m protoreflect.Message) []protoreflect.FieldDescriptor {
+	var fields []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if !fd.IsExtension() && isDefault(fd, v) {
+			return true
+		}
+		fields = append(fields, fd)
+		return true
+	})
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Number() < fields[j].Number()
+	})
+	return fields
+}
+
+// isDefault reports whether v is the default value of the singular scalar
+// field fd.
+func isDefault(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+	if fd.IsList() || fd.IsMap() || fd.Message() != nil {
+		return false
+	}
+	def := fd.Default()
+	if fd.Kind() == protoreflect.BytesKind {
+		return bytes.Equal(v.Bytes(), def.Bytes())
+	}
+	return v.Interface() == def.Interface()
+}
+
+// hasOptions reports whether an options message has any options to write.
+func (p *printer) hasOptions(opts proto.Message) bool {
+	if opts == nil || !opts.ProtoReflect().IsValid() {
+		return false
+	}
+	return len(optionFields(opts.ProtoReflect())) > 0
+}
+
+// options writes the option statements for an options message.
+func (p *printer) options(opts proto.Message) error {
+	if !p.hasOptions(opts) {
+		return nil
+	}
+	m := opts.ProtoReflect()
+	for _, fd := range optionFields(m) {
+		v := m.Get(fd)
+		values := []protoreflect.Value{v}
+		if fd.IsList() {
+			values = values[:0]
+			for i := 0; i < v.List().Len(); i++ {
+				values = append(values, v.List().Get(i))
+			}
+		}
+		for _, v := range values {
+			text, err := p.value(fd, v, true)
+			if err != nil {
+				return err
+			}
+			p.line("option %s = %s;", optionName(fd), text)
+		}
+	}
+	return nil
+}
+
+// inlineOptions returns the options of a field or enum value, as written
+// between brackets.
+func (p *printer) inlineOptions(opts proto.Message) ([]string, error) {
+	if !p.hasOptions(opts) {
+		return nil, nil
+	}
+	m := opts.ProtoReflect()
+	var list []string
+	for _, fd := range optionFields(m) {
+		v := m.Get(fd)
+		values := []protoreflect.Value{v}
+		if fd.IsList() {
+			values = values[:0]
+			for i := 0; i < v.List().Len(); i++ {
+				values = append(values, v.List().Get(i))
+			}
+		}
+		for _, v := range values {
+			text, err := p.value(fd, v, false)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, optionName(fd)+" = "+text)
+		}
+	}
+	return list, nil
+}
+
+// optionName returns the name of an option field, wrapped in parentheses if
+// it is an extension.
+func optionName(fd protoreflect.FieldDescriptor) string {
+	if fd.IsExtension() {
+		return "(" + string(fd.FullName()) + ")"
+	}
+	return string(fd.Name())
+}
+
+// value formats a single value of a field. Messages are written in the text
+// format, over multiple lines if multiline is set.
+func (p *printer) value(fd protoreflect.FieldDescriptor, v protoreflect.Value, multiline bool) (string, error) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return p.messageValue(v.Message(), multiline)
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name()), nil
+		}
+		return strconv.Itoa(int(v.Enum())), nil
+	case protoreflect.StringKind:
+		return quote(v.String()), nil
+	case protoreflect.BytesKind:
+		return quote(string(v.Bytes())), nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f := v.Float()
+		switch {
+		case math.IsInf(f, 1):
+			return "inf", nil
+		case math.IsInf(f, -1):
+			return "-inf", nil
+		case math.IsNaN(f):
+			return "nan", nil
+		}
+		bitSize := 64
+		if fd.Kind() == protoreflect.FloatKind {
+			bitSize = 32
+		}
+		return strconv.FormatFloat(f, 'g', -1, bitSize), nil
+	}
+	return v.String(), nil
+}
+
+// messageValue formats a message in the text format, as used for aggregate
+// option values.
+func (p *printer) messageValue(m protoreflect.Message, multiline bool) (string, error) {
+	var entries []string
+	for _, fd := range optionFields(m) {
+		name := string(fd.Name())
+		if fd.IsExtension() {
+			name = "[" + string(fd.FullName()) + "]"
+		}
+		v := m.Get(fd)
+		var values []protoreflect.Value
+		switch {
+		case fd.IsList():
+			for i := 0; i < v.List().Len(); i++ {
+				values = append(values, v.List().Get(i))
+			}
+		case fd.IsMap():
+			entry, err := p.mapValues(fd, v.Map(), multiline)
+			if err != nil {
+				return "", err
+			}
+			for _, e := range entry {
+				entries = append(entries, name+" "+e)
+			}
+			continue
+		default:
+			values = []protoreflect.Value{v}
+		}
+		for _, v := range values {
+			text, err := p.value(fd, v, multiline)
+			if err != nil {
+				return "", err
+			}
+			entries = append(entries, name+": "+text)
+		}
+	}
+	return formatAggregate(entries, multiline, p.indent), nil
+}
+
+// mapValues formats the entries of a map field in the text format, sorted by
+// key.
+func (p *printer) mapValues(fd protoreflect.FieldDescriptor, m protoreflect.Map, multiline bool) ([]string, error) {
+	var keys []protoreflect.MapKey
+	m.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys = append(keys, k)
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	var entries []string
+	for _, k := range keys {
+		key, err := p.value(fd.MapKey(), k.Value(), multiline)
+		if err != nil {
+			return nil, err
+		}
+		val, err := p.value(fd.MapValue(), m.Get(k), multiline)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, formatAggregate([]string{"key: " + key, "value: " + val}, false, 0))
+	}
+	return entries, nil
+}
+
+// formatAggregate wraps text format entries in braces. Multi-line values are
+// indented one level deeper than indent.
+func formatAggregate(entries []string, multiline bool, indent int) string {
+	if len(entries) == 0 {
+		return "{}"
+	}
+	if !multiline {
+		return "{ " + strings.Join(entries, " ") + " }"
+	}
+	prefix := strings.Repeat("  ", indent+1)
+	var sb strings.Builder
+	sb.WriteString("{\n")
+	for _, e := range entries {
+		// Nested messages were formatted at the same indentation, so
+		// indent their lines too.
+		e = strings.ReplaceAll(e, "\n", "\n  ")
+		sb.WriteString(prefix + e + "\n")
+	}
+	sb.WriteString(strings.Repeat("  ", indent) + "}")
+	return sb.String()
+}
+
+// jsonName returns the JSON name protoc derives from a field name.
+func jsonName(name string) string {
+	var sb strings.Builder
+	upper := false
+	for _, r := range name {
+		switch {
+		case r == '_':
+			upper = true
+		case upper && 'a' <= r && r <= 'z':
+			sb.WriteRune(r - 'a' + 'A')
+			upper = false
+		default:
+			sb.WriteRune(r)
+			upper = false
+		}
+	}
+	return sb.String()
+}
+
+// quote returns a string literal in the proto syntax, escaping quotes and
+// non-printable bytes.
+func quote(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			sb.WriteString(`\"`)
+		case c == '\\':
+			sb.WriteString(`\\`)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c == '\r':
+			sb.WriteString(`\r`)
+		case c == '\t':
+			sb.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&sb, `\%03o`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// subPath returns the path of an element of kind in the element at path.
+func subPath(path []int32, kind, index int32) []int32 {
+	sub := make([]int32, 0, len(path)+2)
+	return append(append(sub, path...), kind, index)
+}
+
+func pathKey(path []int32) string {
+	var sb strings.Builder
+	for i, n := range path {
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		sb.WriteString(strconv.Itoa(int(n)))
+	}
+	return sb.String()
+}
+
+func containsString(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt32(list []int32, n int32) bool {
+	for _, m := range list {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package format
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"go/ast"
 	"go/parser"
@@ -11,12 +12,14 @@ import (
 	"io/ioutil"
 	"os"
 	"reflect"
-	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/diagnostic"
 	"github.com/gunk/gunk/loader"
+	"github.com/gunk/gunk/log"
+	"github.com/gunk/gunk/watch"
 	"github.com/kenshaw/snaker"
 )
 
@@ -41,8 +44,9 @@ func New(cfg *config.Config) (*Formatter, error) {
 	}, nil
 }
 
-// Run formats Gunk files to be canonically formatted.
-func Run(dir string, args ...string) error {
+// Run formats Gunk files to be canonically formatted. The errors loading the
+// packages are added to diags, or printed if it's nil.
+func Run(dir string, diags *diagnostic.Printer, args ...string) error {
 	if len(args) == 1 && args[0] == "-" {
 		buf, err := io.ReadAll(os.Stdin)
 		if err != nil {
@@ -59,7 +63,7 @@ func Run(dir string, args ...string) error {
 		return nil
 	}
 	fset := token.NewFileSet()
-	l := loader.Loader{Dir: dir, Fset: fset}
+	l := loader.Loader{Dir: dir, Fset: fset, Diagnostics: diags}
 	pkgs, err := l.Load(args...)
 	if err != nil {
 		return fmt.Errorf("error on loading: %w", err)
@@ -67,7 +71,7 @@ func Run(dir string, args ...string) error {
 	if len(pkgs) == 0 {
 		return fmt.Errorf("no Gunk packages to format")
 	}
-	if loader.PrintErrors(pkgs) > 0 {
+	if l.ReportErrors(os.Stderr, pkgs) > 0 {
 		return fmt.Errorf("encountered package loading errors")
 	}
 	for _, pkg := range pkgs {
@@ -134,14 +138,21 @@ func (f *Formatter) formatFile(fset *token.FileSet, file *ast.File) (_ []byte, f
 			}
 		}
 	}()
+	// Numbers reserved by each struct, which must not be used when
+	// numbering its fields.
+	reserved := make(map[*ast.StructType]*loader.Reserved)
 	ast.Inspect(file, func(node ast.Node) bool {
 		switch node := node.(type) {
+		case *ast.GenDecl:
+			if err := structsReserved(fset, file, node, reserved); err != nil {
+				panic(inspectError{err})
+			}
 		case *ast.CommentGroup:
 			if err := f.formatComment(fset, node); err != nil {
 				panic(inspectError{err})
 			}
 		case *ast.StructType:
-			if err := f.formatStruct(fset, node); err != nil {
+			if err := f.formatStruct(fset, node, reserved[node]); err != nil {
 				panic(inspectError{err})
 			}
 		}
@@ -189,22 +200,58 @@ func (f *Formatter) formatComment(fset *token.FileSet, group *ast.CommentGroup)
 			doc += "\n"
 		}
 	}
-	*group = *loader.CommentFromText(group, doc)
+	// The directives, such as //gunk:nolint, are left out of the text of
+	// the comment, so add them back at its end, like gofmt does.
+	var directives []*ast.Comment
+	for _, c := range group.List {
+		single := &ast.CommentGroup{List: []*ast.Comment{c}}
+		if strings.HasPrefix(c.Text, "//") && strings.TrimSpace(c.Text[2:]) != "" && single.Text() == "" {
+			directives = append(directives, &ast.Comment{Text: c.Text})
+		}
+	}
+	orig := *group
+	*group = *loader.CommentFromText(&orig, doc)
+	if len(directives) > 0 {
+		last := group.List[len(group.List)-1]
+		if len(group.List) > 1 {
+			last.Slash = token.NoPos
+		}
+		directives[len(directives)-1].Slash = orig.End()
+		group.List = append(group.List, directives...)
+	}
+	return nil
+}
+
+// structsReserved records the numbers reserved with a message.Reserved
+// annotation by the structs declared in decl, in file.
+func structsReserved(fset *token.FileSet, file *ast.File, decl *ast.GenDecl, reserved map[*ast.StructType]*loader.Reserved) error {
+	if decl.Tok != token.TYPE {
+		return nil
+	}
+	for _, spec := range decl.Specs {
+		tspec := spec.(*ast.TypeSpec)
+		st, ok := tspec.Type.(*ast.StructType)
+		if !ok {
+			continue
+		}
+		r, err := loader.DeclReserved(fset, nil, file, decl, tspec)
+		if err != nil {
+			return fmt.Errorf("%s: %v", fset.Position(tspec.Pos()), err)
+		}
+		reserved[st] = r
+	}
 	return nil
 }
 
-func (f *Formatter) formatStruct(fset *token.FileSet, st *ast.StructType) error {
+func (f *Formatter) formatStruct(fset *token.FileSet, st *ast.StructType, reserved *loader.Reserved) error {
 	if st.Fields == nil {
 		return nil
 	}
-	// Figure out list of missing protobuf numbers.
+	// Figure out list of missing protobuf numbers, skipping the reserved
+	// ones.
 	missingNum := make([]int, 0, len(st.Fields.List))
 	if !f.Config.Format.PB { // Skip this if we are not going to use it anyways.
-		// Find all unusedFields.
-		unusedFields := make(map[int]bool, len(st.Fields.List))
-		for i := 1; i <= len(st.Fields.List); i++ {
-			unusedFields[i] = true
-		}
+		usedFields := make(map[int]bool, len(st.Fields.List))
 		for _, field := range st.Fields.List {
 			if field.Tag == nil {
 				continue
@@ -224,14 +271,18 @@ func (f *Formatter) formatStruct(fset *token.FileSet, st *ast.StructType) error
 				// this code with the code in generate, they do very similar things?
 				return fmt.Errorf("%s: struct field tag for pb contains a non-number %q", errorPos, pb)
 			}
-			delete(unusedFields, pbNum)
+			usedFields[pbNum] = true
 		}
-		for k := range unusedFields {
-			missingNum = append(missingNum, k)
+		for n := reserved.NextUnreserved(1); len(missingNum) < len(st.Fields.List); n = reserved.NextUnreserved(n + 1) {
+			if !usedFields[n] {
+				missingNum = append(missingNum, n)
+			}
 		}
-		sort.Ints(missingNum)
 	}
-	for i, field := range st.Fields.List {
+	pbNum := 0
+	for _, field := range st.Fields.List {
+		// Number fields in order, skipping reserved numbers.
+		pbNum = reserved.NextUnreserved(pbNum + 1)
 		var key []string
 		var value map[string]string
 		if field.Tag != nil {
@@ -252,7 +303,7 @@ func (f *Formatter) formatStruct(fset *token.FileSet, st *ast.StructType) error
 		// Insert JSON and protobuf key.
 		entries := make([]string, 0, len(key))
 		if f.Config.Format.PB {
-			entries = append(entries, fmt.Sprintf("pb:%q", strconv.Itoa(i+1)))
+			entries = append(entries, fmt.Sprintf("pb:%q", strconv.Itoa(pbNum)))
 		} else if _, ok := value["pb"]; ok {
 			entries = append(entries, fmt.Sprintf("pb:%q", value["pb"]))
 		} else {
@@ -328,3 +379,22 @@ func parseTag(tag string) ([]string, map[string]string, error) {
 
 	return keys, values, nil
 }
+
+// Watch formats the Gunk packages like Run, and then watches the .gunk and
+// .gunkconfig files under dir until ctx is done, formatting the packages of
+// the files that change. Errors are logged rather than returned.
+func Watch(ctx context.Context, dir string, logger *log.Logger, args ...string) error {
+	// Only the packages of the changed files are loaded again to find the
+	// affected ones.
+	l := loader.Loader{Dir: dir, Fset: token.NewFileSet()}
+	return watch.Run(ctx, dir, logger, func(changed []string) error {
+		if changed == nil {
+			return Run(dir, nil, args...)
+		}
+		affected, err := watch.Affected(&l, args, changed, false)
+		if err != nil || len(affected) == 0 {
+			return err
+		}
+		return Run(dir, nil, affected...)
+	})
+}
@@ -0,0 +1,229 @@
+package generate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// cacheVersion is part of every cache key, so that entries written by an
+// incompatible version of gunk are never used.
+const cacheVersion = "gunk generate cache v1"
+
+const (
+	// cacheTrimInterval is how often to look for unused cache entries.
+	cacheTrimInterval = 24 * time.Hour
+	// cacheTrimLimit is how long an entry may go unused before it is
+	// removed.
+	cacheTrimLimit = 5 * 24 * time.Hour
+	// cacheTouchInterval is how old the modification time of an entry has
+	// to be for it to be updated when the entry is used.
+	cacheTouchInterval = time.Hour
+)
+
+// genCache is a content-addressed cache of the outputs of code generators.
+// The output of a generator only depends on the bytes it is given and on the
+// generator binary, so those make up the key for its output. The output of
+// postproc commands is cached the same way, with their arguments, which
+// include the path of the file, as part of the key.
+type genCache struct {
+	dir string
+
+	mu  sync.Mutex
+	ids map[string]string // identity of binaries, by path
+}
+
+// openCache opens the generation cache in the gunk cache directory. It
+// returns nil if the cache is disabled by setting GUNK_CACHE=off.
+func openCache() (*genCache, error) {
+	if os.Getenv("GUNK_CACHE") == "off" {
+		return nil, nil
+	}
+	cachePath, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	if dir := os.Getenv("GUNK_CACHE_DIR"); dir != "" {
+		// Allow overriding the cache dir entirely. Mainly for
+		// the tests.
+		cachePath = dir
+	}
+	c := &genCache{
+		dir: filepath.Join(cachePath, "gunk", "generate"),
+		ids: make(map[string]string),
+	}
+	if err := mkdirAll(c.dir); err != nil {
+		return nil, err
+	}
+	c.trim()
+	return c, nil
+}
+
+// run returns the cached response of running the binary with the input, or
+// calls gen to run it and caches the response if it didn't fail. A nil
+// genCache runs gen every time.
+func (c *genCache) run(bin string, input []byte, gen func() (*pluginpb.CodeGeneratorResponse, error)) (*pluginpb.CodeGeneratorResponse, error) {
+	if c == nil {
+		return gen()
+	}
+	key, ok := c.key(bin, input)
+	if !ok {
+		// The binary can't be found; let the generator fail as
+		// usual.
+		return gen()
+	}
+	if resp := c.get(key); resp != nil {
+		return resp, nil
+	}
+	resp, err := gen()
+	if err != nil || resp.GetError() != "" {
+		return resp, err
+	}
+	// Failing to write to the cache only means the generator will be run
+	// again next time, so the error is ignored.
+	_ = c.put(key, resp)
+	return resp, nil
+}
+
+// key returns the cache key for running the binary with the input. It reports
+// false if the binary can't be identified.
+func (c *genCache) key(bin string, input []byte) (string, bool) {
+	id, ok := c.binaryID(bin)
+	if !ok {
+		return "", false
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", cacheVersion, id)
+	h.Write(input)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// binaryID identifies the binary by its path, size and modification time,
+// which change whenever it is reinstalled.
+func (c *genCache) binaryID(bin string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if id, ok := c.ids[bin]; ok {
+		return id, true
+	}
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return "", false
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return "", false
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false
+	}
+	id := fmt.Sprintf("%s %d %d", path, info.Size(), info.ModTime().UnixNano())
+	c.ids[bin] = id
+	return id, true
+}
+
+func (c *genCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// get returns the cached response for key, or nil if there is none.
+func (c *genCache) get(key string) *pluginpb.CodeGeneratorResponse {
+	path := c.path(key)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var resp pluginpb.CodeGeneratorResponse
+	if err := proto.Unmarshal(data, &resp); err != nil {
+		return nil
+	}
+	// Keep the entry from being trimmed while it's in use.
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > cacheTouchInterval {
+		now := time.Now()
+		os.Chtimes(path, now, now)
+	}
+	return &resp
+}
+
+// put stores the response for key. The entry is written to a temporary file
+// first, so that concurrent gunk processes never read a partial entry.
+func (c *genCache) put(key string, resp *pluginpb.CodeGeneratorResponse) error {
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	path := c.path(key)
+	if err := mkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), key+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// trim removes the entries that haven't been used for cacheTrimLimit. To keep
+// it cheap, it does so at most once every cacheTrimInterval.
+func (c *genCache) trim() {
+	stamp := filepath.Join(c.dir, "trim.txt")
+	if info, err := os.Stat(stamp); err == nil && time.Since(info.ModTime()) < cacheTrimInterval {
+		return
+	}
+	if err := writeFile(stamp, []byte(time.Now().Format(time.RFC3339)+"\n")); err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-cacheTrimLimit)
+	filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || path == stamp {
+			return nil
+		}
+		if info.ModTime().Before(cutoff) {
+			os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// readFiles returns the files in dir as generator output files, with names
+// relative to dir.
+func readFiles(dir string) ([]*pluginpb.CodeGeneratorResponse_File, error) {
+	var files []*pluginpb.CodeGeneratorResponse_File
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, &pluginpb.CodeGeneratorResponse_File{
+			Name:    proto.String(filepath.ToSlash(rel)),
+			Content: proto.String(string(content)),
+		})
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,108 @@
+package generate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/diff"
+)
+
+// staleFile is a generated file whose content differs from the file on disk,
+// which doesn't exist on disk, or which is no longer generated.
+type staleFile struct {
+	path     string
+	old, new []byte
+	missing  bool
+	removed  bool // no longer generated, so it would be removed
+}
+
+// writeFile writes a file generated by gen for the package, recording it in
+// the package's manifest. With g.check, the file is compared to the one on
+// disk instead, and recorded as stale if they differ.
+//
+// The files of generate_single generators are not part of any package, and
+// are not recorded, so they are never removed nor reported as no longer
+// generated. When writing to an Output, the file is only collected.
+func (g *Generator) writeFile(pkgPath, gen, path string, data []byte) error {
+	g.outputsMu.Lock()
+	if pkgPath != "" {
+		g.outputs[pkgPath] = append(g.outputs[pkgPath], manifestEntry{gen: gen, path: path})
+	}
+	if g.collected != nil {
+		g.collected[path] = data
+	}
+	g.outputsMu.Unlock()
+	if g.collected != nil {
+		return nil
+	}
+	if !g.check {
+		return writeFile(path, data)
+	}
+	old, err := ioutil.ReadFile(path)
+	missing := errors.Is(err, os.ErrNotExist)
+	if err != nil && !missing {
+		return err
+	}
+	if !missing && bytes.Equal(old, data) {
+		return nil
+	}
+	g.addStale(staleFile{path: path, old: old, new: data, missing: missing})
+	return nil
+}
+
+func (g *Generator) addStale(f staleFile) {
+	g.outputsMu.Lock()
+	defer g.outputsMu.Unlock()
+	g.stale = append(g.stale, f)
+}
+
+// mkdirAll creates a directory for generated files, unless g.check is set or
+// the files are written to an Output.
+func (g *Generator) mkdirAll(path string) error {
+	if g.check || g.collected != nil {
+		return nil
+	}
+	return mkdirAll(path)
+}
+
+// reportStale writes a unified diff of the stale generated files to w, and
+// returns an error listing them, if there are any.
+func (g *Generator) reportStale(w io.Writer) error {
+	if len(g.stale) == 0 {
+		return nil
+	}
+	// The generators may have finished in any order.
+	sort.Slice(g.stale, func(i, j int) bool {
+		return g.stale[i].path < g.stale[j].path
+	})
+	wd, _ := os.Getwd()
+	var list strings.Builder
+	for _, f := range g.stale {
+		path := f.path
+		if rel, err := filepath.Rel(wd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+		oldName, newName, status := path, path, "stale"
+		switch {
+		case f.missing:
+			oldName, status = "/dev/null", "missing"
+		case f.removed:
+			newName, status = "/dev/null", "not generated"
+		}
+		if err := diff.Text(oldName, newName, f.old, f.new, w); err != nil {
+			return err
+		}
+		fmt.Fprintf(&list, "\n\t%s (%s)", path, status)
+	}
+	if g.single {
+		list.WriteString("\n(files no longer generated by generate_single generators are not checked, as they aren't recorded in any manifest)")
+	}
+	return fmt.Errorf("generated files are not up to date:%s", list.String())
+}
@@ -0,0 +1,377 @@
+package generate
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"math"
+	"strings"
+
+	"github.com/gunk/gunk/loader"
+	"github.com/gunk/gunk/reflectutil"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// optionExtension links a Gunk annotation type to the proto extension it
+// sets, using the option.Extension annotation on the type declaration:
+//
+//	// +gunk option.Extension{
+//	//         File: "acme/auth/options.proto",
+//	//         Name: "acme.auth.pii",
+//	// }
+//	type PII bool
+type optionExtension struct {
+	File string // proto file declaring the extension
+	Name string // full name of the extension
+}
+
+// customOption is an option set using an annotation linked to a proto
+// extension. It can only be set once the proto file declaring the extension
+// has been loaded, so it is recorded during translation and set by
+// applyCustomOptions.
+type customOption struct {
+	opts proto.Message // options message to set the extension on
+	ext  optionExtension
+	tag  loader.GunkTag
+	pos  token.Pos // position of the annotated declaration
+}
+
+// customOption records the option set by a Gunk tag whose type is linked to a
+// proto extension, adding the proto file declaring the extension as a
+// dependency of the current file. kind is the kind of option, such as "field",
+// used in the error if the tag's type isn't linked to an extension.
+func (g *Generator) customOption(opts proto.Message, kind string, tag loader.GunkTag) error {
+	ext, err := g.optionExtension(tag.Type)
+	if err != nil {
+		return err
+	}
+	if ext == nil {
+		return fmt.Errorf("gunk %s option %q not supported", kind, tag.Type)
+	}
+	g.customOpts = append(g.customOpts, customOption{
+		opts: opts,
+		ext:  *ext,
+		tag:  tag,
+		pos:  g.curPos,
+	})
+	g.addProtoDep(ext.File)
+	return nil
+}
+
+// optionExtension returns the proto extension linked to a Gunk annotation
+// type with option.Extension, or nil if there is none.
+func (g *Generator) optionExtension(typ types.Type) (*optionExtension, error) {
+	named, ok := typ.(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return nil, nil
+	}
+	obj := named.Obj()
+	pkg := g.gunkPkgs[obj.Pkg().Path()]
+	if pkg == nil {
+		return nil, nil
+	}
+	for _, f := range pkg.GunkSyntax {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				tspec := spec.(*ast.TypeSpec)
+				if tspec.Name.Name != obj.Name() {
+					continue
+				}
+				for _, tag := range pkg.GunkTags[tspec] {
+					if tag.Type.String() != "github.com/gunk/opt/option.Extension" {
+						continue
+					}
+					ext := &optionExtension{}
+					reflectutil.UnmarshalAST(ext, tag.Expr)
+					if ext.File == "" || ext.Name == "" {
+						return nil, fmt.Errorf("option.Extension on %s must set File and Name", obj.Name())
+					}
+					return ext, nil
+				}
+				return nil, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// applyCustomOptions sets the options recorded by customOption. All the proto
+// files declaring the extensions must already be loaded.
+func (g *Generator) applyCustomOptions() error {
+	files := new(protoregistry.Files)
+	for _, opt := range g.customOpts {
+		if err := g.registerProtoFile(files, opt.ext.File); err != nil {
+			return fmt.Errorf("%s: %v", g.Fset.Position(opt.pos), err)
+		}
+		if err := setCustomOption(files, opt); err != nil {
+			return fmt.Errorf("%s: %v", g.Fset.Position(opt.pos), err)
+		}
+	}
+	g.customOpts = nil
+	return nil
+}
+
+// registerProtoFile adds a loaded proto file and its dependencies to files,
+// unless they were added already.
+func (g *Generator) registerProtoFile(files *protoregistry.Files, name string) error {
+	if _, err := files.FindFileByPath(name); err == nil {
+		return nil
+	}
+	// Prefer the compiled-in descriptors, such as descriptor.proto, so that
+	// the extended options messages match the ones we set extensions on.
+	if fd, err := protoregistry.GlobalFiles.FindFileByPath(name); err == nil {
+		return files.RegisterFile(fd)
+	}
+	pfile, ok := g.allProto[name]
+	if !ok {
+		return fmt.Errorf("proto file %s is not loaded", name)
+	}
+	for _, dep := range pfile.Dependency {
+		if err := g.registerProtoFile(files, dep); err != nil {
+			return err
+		}
+	}
+	fd, err := protodesc.NewFile(pfile, files)
+	if err != nil {
+		return err
+	}
+	return files.RegisterFile(fd)
+}
+
+// setCustomOption sets a custom option on its options message, converting the
+// Gunk tag to a value of the extension's type.
+func setCustomOption(files *protoregistry.Files, opt customOption) error {
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(opt.ext.Name))
+	if err != nil {
+		return fmt.Errorf("extension %s not found in %s", opt.ext.Name, opt.ext.File)
+	}
+	xd, ok := desc.(protoreflect.ExtensionDescriptor)
+	if !ok || !xd.IsExtension() {
+		return fmt.Errorf("%s is not an extension", opt.ext.Name)
+	}
+	msg := opt.opts.ProtoReflect()
+	if got, want := xd.ContainingMessage().FullName(), msg.Descriptor().FullName(); got != want {
+		return fmt.Errorf("extension %s extends %s, not %s", opt.ext.Name, got, want)
+	}
+	xt := dynamicpb.NewExtensionType(xd)
+	v, err := optionValue(xd, xt.New(), opt.tag.Expr, opt.tag.Value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %v", opt.ext.Name, err)
+	}
+	msg.Set(xt.TypeDescriptor(), v)
+	return nil
+}
+
+// optionValue converts a Gunk tag expression to a value for the field fd.
+// Lists and messages are filled into zero, which must be a new mutable value
+// for the field. val is the constant value of expr, if known.
+func optionValue(fd protoreflect.FieldDescriptor, zero protoreflect.Value, expr ast.Expr, val constant.Value) (protoreflect.Value, error) {
+	expr = unconvert(expr)
+	switch {
+	case fd.IsMap():
+		return protoreflect.Value{}, fmt.Errorf("map field %s is not supported", fd.Name())
+	case fd.IsList():
+		lit, ok := expr.(*ast.CompositeLit)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("%s must be a slice literal", fd.Name())
+		}
+		list := zero.List()
+		for _, elt := range lit.Elts {
+			v, err := singularOptionValue(fd, list.NewElement(), elt, nil)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			list.Append(v)
+		}
+		return zero, nil
+	}
+	return singularOptionValue(fd, zero, expr, val)
+}
+
+// singularOptionValue is like optionValue, for a single element of the
+// field's kind.
+func singularOptionValue(fd protoreflect.FieldDescriptor, zero protoreflect.Value, expr ast.Expr, val constant.Value) (protoreflect.Value, error) {
+	expr = unconvert(expr)
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		lit, ok := expr.(*ast.CompositeLit)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("%s must be a struct literal", fd.Name())
+		}
+		msg := zero.Message()
+		for _, elt := range lit.Elts {
+			kv, ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				return protoreflect.Value{}, fmt.Errorf("fields of %s must be keyed", fd.Name())
+			}
+			key, ok := kv.Key.(*ast.Ident)
+			if !ok {
+				return protoreflect.Value{}, fmt.Errorf("invalid key in %s", fd.Name())
+			}
+			field := optionField(msg.Descriptor(), key.Name)
+			if field == nil {
+				return protoreflect.Value{}, fmt.Errorf("%s has no field %s", msg.Descriptor().FullName(), key.Name)
+			}
+			v, err := optionValue(field, msg.NewField(field), kv.Value, nil)
+			if err != nil {
+				return protoreflect.Value{}, err
+			}
+			msg.Set(field, v)
+		}
+		return zero, nil
+	case protoreflect.EnumKind:
+		if val != nil {
+			break
+		}
+		// Enum values can be given by name, as well as by number.
+		var name string
+		switch x := expr.(type) {
+		case *ast.Ident:
+			name = x.Name
+		case *ast.SelectorExpr:
+			name = x.Sel.Name
+		}
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(name)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+	}
+	if val == nil {
+		var err error
+		if val, err = constValue(expr); err != nil {
+			return protoreflect.Value{}, err
+		}
+	}
+	return scalarOptionValue(fd, val)
+}
+
+// scalarOptionValue converts a constant to a value for the scalar or enum
+// field fd.
+func scalarOptionValue(fd protoreflect.FieldDescriptor, val constant.Value) (protoreflect.Value, error) {
+	invalid := fmt.Errorf("cannot use %s as %s value for %s", val, fd.Kind(), fd.Name())
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if val.Kind() != constant.Bool {
+			return protoreflect.Value{}, invalid
+		}
+		return protoreflect.ValueOfBool(constant.BoolVal(val)), nil
+	case protoreflect.StringKind, protoreflect.BytesKind:
+		if val.Kind() != constant.String {
+			return protoreflect.Value{}, invalid
+		}
+		if fd.Kind() == protoreflect.BytesKind {
+			return protoreflect.ValueOfBytes([]byte(constant.StringVal(val))), nil
+		}
+		return protoreflect.ValueOfString(constant.StringVal(val)), nil
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		if val.Kind() != constant.Int && val.Kind() != constant.Float {
+			return protoreflect.Value{}, invalid
+		}
+		f, _ := constant.Float64Val(val)
+		if fd.Kind() == protoreflect.FloatKind {
+			return protoreflect.ValueOfFloat32(float32(f)), nil
+		}
+		return protoreflect.ValueOfFloat64(f), nil
+	case protoreflect.EnumKind:
+		n, ok := constant.Int64Val(constant.ToInt(val))
+		if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+			return protoreflect.Value{}, invalid
+		}
+		if fd.Enum().Values().ByNumber(protoreflect.EnumNumber(n)) == nil {
+			return protoreflect.Value{}, fmt.Errorf("%s has no value %d", fd.Enum().FullName(), n)
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(n)), nil
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		n, ok := constant.Int64Val(constant.ToInt(val))
+		if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+			return protoreflect.Value{}, invalid
+		}
+		return protoreflect.ValueOfInt32(int32(n)), nil
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n, ok := constant.Int64Val(constant.ToInt(val))
+		if !ok {
+			return protoreflect.Value{}, invalid
+		}
+		return protoreflect.ValueOfInt64(n), nil
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		n, ok := constant.Uint64Val(constant.ToInt(val))
+		if !ok || n > math.MaxUint32 {
+			return protoreflect.Value{}, invalid
+		}
+		return protoreflect.ValueOfUint32(uint32(n)), nil
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n, ok := constant.Uint64Val(constant.ToInt(val))
+		if !ok {
+			return protoreflect.Value{}, invalid
+		}
+		return protoreflect.ValueOfUint64(n), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("%s fields are not supported", fd.Kind())
+}
+
+// optionField returns the field of a message matching a Go struct field name,
+// ignoring case and underscores, or nil if there is none.
+func optionField(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if strings.EqualFold(strings.ReplaceAll(string(field.Name()), "_", ""), name) {
+			return field
+		}
+	}
+	return nil
+}
+
+// unconvert removes the type conversions around an expression, such as the
+// one in auth.Scope("read").
+func unconvert(expr ast.Expr) ast.Expr {
+	for {
+		switch x := expr.(type) {
+		case *ast.ParenExpr:
+			expr = x.X
+		case *ast.CallExpr:
+			if len(x.Args) != 1 {
+				return expr
+			}
+			expr = x.Args[0]
+		default:
+			return expr
+		}
+	}
+}
+
+// constValue evaluates a constant expression made of literals, as found in
+// Gunk tags.
+func constValue(expr ast.Expr) (constant.Value, error) {
+	switch x := unconvert(expr).(type) {
+	case *ast.BasicLit:
+		if val := constant.MakeFromLiteral(x.Value, x.Kind, 0); val.Kind() != constant.Unknown {
+			return val, nil
+		}
+	case *ast.Ident:
+		switch x.Name {
+		case "true":
+			return constant.MakeBool(true), nil
+		case "false":
+			return constant.MakeBool(false), nil
+		}
+	case *ast.UnaryExpr:
+		if x.Op == token.SUB || x.Op == token.ADD {
+			val, err := constValue(x.X)
+			if err != nil {
+				return nil, err
+			}
+			return constant.UnaryOp(x.Op, val, 0), nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported expression %T", expr)
+}
@@ -0,0 +1,190 @@
+package generate
+
+import (
+	"go/constant"
+	"go/parser"
+	"strings"
+	"testing"
+
+	"github.com/gunk/gunk/loader"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// customOptionsProto is the equivalent of:
+//
+//	syntax = "proto3";
+//	package acme.auth;
+//	import "google/protobuf/descriptor.proto";
+//	enum Level { LOW = 0; HIGH = 1; }
+//	message Policy { string role = 1; repeated Level levels = 2; int32 max_age = 3; }
+//	extend google.protobuf.FieldOptions {
+//		bool pii = 50001;
+//		repeated string scopes = 50002;
+//		Level level = 50003;
+//		double ratio = 50004;
+//	}
+//	extend google.protobuf.MethodOptions { Policy policy = 50001; }
+var customOptionsProto = &descriptorpb.FileDescriptorProto{
+	Name:       proto.String("acme/auth/options.proto"),
+	Package:    proto.String("acme.auth"),
+	Syntax:     proto.String("proto3"),
+	Dependency: []string{"google/protobuf/descriptor.proto"},
+	EnumType: []*descriptorpb.EnumDescriptorProto{{
+		Name: proto.String("Level"),
+		Value: []*descriptorpb.EnumValueDescriptorProto{
+			{Name: proto.String("LOW"), Number: proto.Int32(0)},
+			{Name: proto.String("HIGH"), Number: proto.Int32(1)},
+		},
+	}},
+	MessageType: []*descriptorpb.DescriptorProto{{
+		Name: proto.String("Policy"),
+		Field: []*descriptorpb.FieldDescriptorProto{
+			customOptionField("role", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", false),
+			customOptionField("levels", 2, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".acme.auth.Level", true),
+			customOptionField("max_age", 3, descriptorpb.FieldDescriptorProto_TYPE_INT32, "", false),
+		},
+	}},
+	Extension: []*descriptorpb.FieldDescriptorProto{
+		customOptionExtension("pii", 50001, descriptorpb.FieldDescriptorProto_TYPE_BOOL, "", false, ".google.protobuf.FieldOptions"),
+		customOptionExtension("scopes", 50002, descriptorpb.FieldDescriptorProto_TYPE_STRING, "", true, ".google.protobuf.FieldOptions"),
+		customOptionExtension("level", 50003, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".acme.auth.Level", false, ".google.protobuf.FieldOptions"),
+		customOptionExtension("ratio", 50004, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, "", false, ".google.protobuf.FieldOptions"),
+		customOptionExtension("policy", 50001, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".acme.auth.Policy", false, ".google.protobuf.MethodOptions"),
+	},
+}
+
+func customOptionField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool) *descriptorpb.FieldDescriptorProto {
+	label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	}
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		Number:   proto.Int32(number),
+		Label:    &label,
+		Type:     &typ,
+		TypeName: protoStringOrNil(typeName),
+	}
+}
+
+func customOptionExtension(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string, repeated bool, extendee string) *descriptorpb.FieldDescriptorProto {
+	field := customOptionField(name, number, typ, typeName, repeated)
+	field.Extendee = proto.String(extendee)
+	return field
+}
+
+func TestCustomOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  proto.Message
+		ext   string
+		tag   string
+		value constant.Value
+		want  string
+		err   string
+	}{
+		{
+			name:  "Bool",
+			opts:  &descriptorpb.FieldOptions{},
+			ext:   "acme.auth.pii",
+			tag:   "auth.PII(true)",
+			value: constant.MakeBool(true),
+			want:  "[acme.auth.pii]:true",
+		},
+		{
+			name: "List",
+			opts: &descriptorpb.FieldOptions{},
+			ext:  "acme.auth.scopes",
+			tag:  `auth.Scopes{"read", "write"}`,
+			want: `[acme.auth.scopes]:"read"[acme.auth.scopes]:"write"`,
+		},
+		{
+			name:  "EnumNumber",
+			opts:  &descriptorpb.FieldOptions{},
+			ext:   "acme.auth.level",
+			tag:   "auth.Level(1)",
+			value: constant.MakeInt64(1),
+			want:  "[acme.auth.level]:HIGH",
+		},
+		{
+			name:  "Float",
+			opts:  &descriptorpb.FieldOptions{},
+			ext:   "acme.auth.ratio",
+			tag:   "auth.Ratio(-2)",
+			value: constant.MakeInt64(-2),
+			want:  "[acme.auth.ratio]:-2",
+		},
+		{
+			name: "Message",
+			opts: &descriptorpb.MethodOptions{},
+			ext:  "acme.auth.policy",
+			tag:  `auth.Policy{Role: "admin", Levels: []auth.Level{HIGH, 0}, MaxAge: 60}`,
+			want: `[acme.auth.policy]:{role:"admin"levels:HIGHlevels:LOWmax_age:60}`,
+		},
+		{
+			name: "Missing",
+			opts: &descriptorpb.FieldOptions{},
+			ext:  "acme.auth.missing",
+			tag:  "auth.Missing(true)",
+			err:  "extension acme.auth.missing not found in acme/auth/options.proto",
+		},
+		{
+			name: "WrongOptions",
+			opts: &descriptorpb.MessageOptions{},
+			ext:  "acme.auth.pii",
+			tag:  "auth.PII(true)",
+			err:  "extension acme.auth.pii extends google.protobuf.FieldOptions, not google.protobuf.MessageOptions",
+		},
+		{
+			name:  "WrongType",
+			opts:  &descriptorpb.FieldOptions{},
+			ext:   "acme.auth.pii",
+			tag:   `auth.PII("yes")`,
+			value: constant.MakeString("yes"),
+			err:   `invalid value for acme.auth.pii: cannot use "yes" as bool value for pii`,
+		},
+		{
+			name: "UnknownField",
+			opts: &descriptorpb.MethodOptions{},
+			ext:  "acme.auth.policy",
+			tag:  `auth.Policy{Owner: "me"}`,
+			err:  "acme.auth.Policy has no field Owner",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			expr, err := parser.ParseExpr(tc.tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := NewGenerator("")
+			g.allProto[customOptionsProto.GetName()] = customOptionsProto
+			g.customOpts = []customOption{{
+				opts: tc.opts,
+				ext:  optionExtension{File: customOptionsProto.GetName(), Name: tc.ext},
+				tag:  loader.GunkTag{Expr: expr, Value: tc.value},
+			}}
+			err = g.applyCustomOptions()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("want error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// prototext randomizes its whitespace, so strip it.
+			got := strings.Join(strings.Fields(prototext.Format(tc.opts)), "")
+			if got != tc.want {
+				t.Fatalf("want %s, got %s", tc.want, got)
+			}
+			// The options must still be serializable.
+			if _, err := proto.Marshal(tc.opts); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
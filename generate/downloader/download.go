@@ -1,6 +1,7 @@
 package downloader
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -59,7 +60,7 @@ func getPaths(name, version string) (*Paths, func(error), error) {
 
 type Downloader interface {
 	Name() string
-	Download(version string, p Paths) (string, error)
+	Download(logger *log.Logger, version string, p Paths) (string, error)
 }
 
 var ds = []Downloader{
@@ -89,10 +90,13 @@ func Has(name string) bool {
 	return false
 }
 
-func Download(name string, version string) (string, error) {
+// Download returns the path of the plugin name at version, downloading and
+// building it if it isn't in the cache yet. The commands run are logged with
+// logger.
+func Download(logger *log.Logger, name string, version string) (string, error) {
 	for _, d := range ds {
 		if d.Name() == name {
-			s, err := download(d, version)
+			s, err := download(logger, d, version)
 			if err != nil {
 				name := fmt.Sprintf("protoc-gen-%s", d.Name())
 				return "", fmt.Errorf("error downloading %s version %s: %w", name, version, err)
@@ -103,7 +107,7 @@ func Download(name string, version string) (string, error) {
 	return "", fmt.Errorf("unknown downloader %q", name)
 }
 
-func download(d Downloader, version string) (s string, err error) {
+func download(logger *log.Logger, d Downloader, version string) (s string, err error) {
 	p, cleanup, err := getPaths(d.Name(), version)
 	if err != nil {
 		return "", err
@@ -123,17 +127,17 @@ func download(d Downloader, version string) (s string, err error) {
 	// so we can more easily debug
 	// (ignore error)
 	os.RemoveAll(p.buildDir)
-	bin, err := d.Download(version, *p)
+	bin, err := d.Download(logger, version, *p)
 	if err != nil {
 		return "", err
 	}
 	if bin != p.binary {
 		// TODO windows?
-		cpCmd := log.ExecCommand("ln",
+		cpCmd := logger.CommandContext(context.Background(), "ln",
 			"-s",
 			bin,
 			p.binary)
-		err = cpCmd.Run()
+		err = logger.Run(cpCmd)
 		if err != nil {
 			return "", err
 		}
@@ -1,6 +1,7 @@
 package downloader
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 
@@ -13,12 +14,12 @@ func (g Go) Name() string {
 	return "go"
 }
 
-func (pd Go) Download(version string, p Paths) (string, error) {
+func (pd Go) Download(logger *log.Logger, version string, p Paths) (string, error) {
 	if err := os.MkdirAll(p.buildDir, 0o755); err != nil {
 		return "", err
 	}
 
-	buildCmd := log.ExecCommand(
+	buildCmd := logger.CommandContext(context.Background(),
 		"go",
 		"install",
 		"google.golang.org/protobuf/cmd/protoc-gen-go@"+version)
@@ -32,7 +33,7 @@ func (pd Go) Download(version string, p Paths) (string, error) {
 		"PATH="+os.Getenv("PATH"),
 		"GOPROXY=https://proxy.golang.org,direct",
 	)
-	err := buildCmd.Run()
+	err := logger.Run(buildCmd)
 	if err != nil {
 		all := "GOBIN=" + p.buildDir + " go install google.golang.org/protobuf/cmd/protoc-gen-go@" + version
 		return "", log.ExecError(all, err)
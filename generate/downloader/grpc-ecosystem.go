@@ -6,6 +6,8 @@ import (
 	"net/http"
 	"os"
 	"runtime"
+
+	"github.com/gunk/gunk/log"
 )
 
 type GrpcEcosystem struct {
@@ -16,7 +18,7 @@ func (ged GrpcEcosystem) Name() string {
 	return ged.Type
 }
 
-func (ged GrpcEcosystem) Download(version string, p Paths) (string, error) {
+func (ged GrpcEcosystem) Download(logger *log.Logger, version string, p Paths) (string, error) {
 	if ged.Type == "swagger" {
 		return "", fmt.Errorf("use protoc-gen-openapiv2 instead of protoc-gen-swagger")
 	}
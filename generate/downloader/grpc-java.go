@@ -7,6 +7,8 @@ import (
 	"os"
 	"runtime"
 	"strings"
+
+	"github.com/gunk/gunk/log"
 )
 
 type GrpcJava struct{}
@@ -15,7 +17,7 @@ func (pd GrpcJava) Name() string {
 	return "grpc-java"
 }
 
-func (pd GrpcJava) Download(version string, p Paths) (string, error) {
+func (pd GrpcJava) Download(logger *log.Logger, version string, p Paths) (string, error) {
 	// The file does not exist. Download it, using dstFile.
 	url, err := pd.downloadURL(runtime.GOOS, runtime.GOARCH, version)
 	if err != nil {
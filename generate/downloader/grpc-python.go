@@ -1,6 +1,7 @@
 package downloader
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -16,8 +17,8 @@ func (g GrpcPython) Name() string {
 	return "grpc-python"
 }
 
-func (g GrpcPython) Download(version string, p Paths) (string, error) {
-	log.Printf("Downloading and building grpc-python. This can take about 15 minutes.")
+func (g GrpcPython) Download(logger *log.Logger, version string, p Paths) (string, error) {
+	logger.Printf("Downloading and building grpc-python. This can take about 15 minutes.")
 	if strings.HasPrefix(version, "0.") {
 		return "", fmt.Errorf("cannot use 0.x version %s", version)
 	}
@@ -26,54 +27,54 @@ func (g GrpcPython) Download(version string, p Paths) (string, error) {
 	}
 	repoPath := `https://github.com/grpc/grpc`
 	cmdArgs := []string{"clone", "--depth", "1", "--branch", version, repoPath, p.buildDir}
-	log.Printf("Cloning main repo.")
-	gitCmd := log.ExecCommand("git", cmdArgs...)
-	err := gitCmd.Run()
+	logger.Printf("Cloning main repo.")
+	gitCmd := logger.CommandContext(context.Background(), "git", cmdArgs...)
+	err := logger.Run(gitCmd)
 	if err != nil {
 		all := "git " + strings.Join(cmdArgs, " ")
 		return "", log.ExecError(all, err)
 	}
-	log.Printf("Cloning submodules.")
+	logger.Printf("Cloning submodules.")
 	cmdArgs = []string{"submodule", "foreach", `git config -f .gitmodules submodule.$sm_path.shallow true`}
-	gitCmd = log.ExecCommand("git", cmdArgs...)
+	gitCmd = logger.CommandContext(context.Background(), "git", cmdArgs...)
 	gitCmd.Dir = p.buildDir
-	err = gitCmd.Run()
+	err = logger.Run(gitCmd)
 	if err != nil {
 		all := "git " + strings.Join(cmdArgs, " ")
 		return "", log.ExecError(all, err)
 	}
 	cmdArgs = []string{"submodule", "update", "--init", "--jobs=6"}
-	gitCmd = log.ExecCommand("git", cmdArgs...)
+	gitCmd = logger.CommandContext(context.Background(), "git", cmdArgs...)
 	gitCmd.Dir = p.buildDir
-	err = gitCmd.Run()
+	err = logger.Run(gitCmd)
 	if err != nil {
 		all := "git " + strings.Join(cmdArgs, " ")
 		return "", log.ExecError(all, err)
 	}
 	// remove .git to save space, but only after checking submodules
-	rmCmd := log.ExecCommand("rm", "-rf", ".git")
+	rmCmd := logger.CommandContext(context.Background(), "rm", "-rf", ".git")
 	rmCmd.Dir = p.buildDir
-	err = rmCmd.Run()
+	err = logger.Run(rmCmd)
 	if err != nil {
 		all := "rm -rf .git"
 		return "", log.ExecError(all, err)
 	}
-	log.Printf("Running cmake.")
+	logger.Printf("Running cmake.")
 	cmakeDir := filepath.Join(p.buildDir, "cmake", "build")
 	if err := os.MkdirAll(cmakeDir, 0o755); err != nil {
 		return "", err
 	}
-	cmakeCmd := log.ExecCommand("cmake", "../..")
+	cmakeCmd := logger.CommandContext(context.Background(), "cmake", "../..")
 	cmakeCmd.Dir = cmakeDir
-	err = cmakeCmd.Run()
+	err = logger.Run(cmakeCmd)
 	if err != nil {
 		all := "cmake ../.."
 		return "", log.ExecError(all, err)
 	}
-	log.Printf("Running make, building grpc_python_plugin")
-	buildCmd := log.ExecCommand("make", "-j", "2", "grpc_python_plugin")
+	logger.Printf("Running make, building grpc_python_plugin")
+	buildCmd := logger.CommandContext(context.Background(), "make", "-j", "2", "grpc_python_plugin")
 	buildCmd.Dir = cmakeDir
-	err = buildCmd.Run()
+	err = logger.Run(buildCmd)
 	if err != nil {
 		all := "make -j 2 grpc_python_plugin"
 		return "", log.ExecError(all, err)
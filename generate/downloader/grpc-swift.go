@@ -2,6 +2,7 @@ package downloader
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os/exec"
 	"path/filepath"
@@ -16,7 +17,7 @@ func (g GrpcSwift) Name() string {
 	return "grpc-swift"
 }
 
-func (g GrpcSwift) Download(version string, p Paths) (string, error) {
+func (g GrpcSwift) Download(logger *log.Logger, version string, p Paths) (string, error) {
 	version = strings.TrimPrefix(version, "v")
 	if strings.HasPrefix(version, "0.") {
 		return "", fmt.Errorf("cannot use 0.x version %s", version)
@@ -27,17 +28,17 @@ func (g GrpcSwift) Download(version string, p Paths) (string, error) {
 	repoPath := `https://github.com/grpc/grpc-swift`
 	binaryPath := filepath.Join(p.buildDir, "protoc-gen-grpc-swift")
 	cmdArgs := []string{"clone", "--depth", "1", "--branch", version, repoPath, p.buildDir}
-	gitCmd := log.ExecCommand("git", cmdArgs...)
-	err := gitCmd.Run()
+	gitCmd := logger.CommandContext(context.Background(), "git", cmdArgs...)
+	err := logger.Run(gitCmd)
 	if err != nil {
 		all := "git " + strings.Join(cmdArgs, " ")
 		return "", log.ExecError(all, err)
 	}
-	buildCmd := log.ExecCommand("make", "plugins")
+	buildCmd := logger.CommandContext(context.Background(), "make", "plugins")
 	buildCmd.Dir = p.buildDir
 	var stderr bytes.Buffer
 	buildCmd.Stderr = &stderr
-	err = buildCmd.Run()
+	err = logger.Run(buildCmd)
 	if err != nil {
 		all := "make plugins"
 		return "", log.ExecError(all, err)
@@ -1,6 +1,7 @@
 package downloader
 
 import (
+	"context"
 	"archive/zip"
 	"bytes"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/gunk/gunk/log"
@@ -25,9 +27,11 @@ const defaultProtocVersion = "v3.9.1"
 // If both version and path are specified and a file already exists at the path,
 // it checks whether the output of `protoc --version` is an exact match.
 //
+// The commands run are logged with logger.
+//
 // Note that this code is safe for concurrent use between multiple goroutines or
 // processes, since it uses a lock file on disk.
-func CheckOrDownloadProtoc(path, version string) (string, error) {
+func CheckOrDownloadProtoc(logger *log.Logger, path, version string) (string, error) {
 	if version == "" {
 		version = defaultProtocVersion
 	}
@@ -57,7 +61,7 @@ func CheckOrDownloadProtoc(path, version string) (string, error) {
 	if unix.Access(dstDir, unix.W_OK) != nil {
 		// we use unwritable dstPath (system protoc),
 		// let's not do any of the locking/downloading and just test it
-		if err := verifyProtocBinary(dstPath, version); err != nil {
+		if err := verifyProtocBinary(logger, dstPath, version); err != nil {
 			return "", err
 		}
 		return dstPath, nil
@@ -78,7 +82,7 @@ func CheckOrDownloadProtoc(path, version string) (string, error) {
 	if os.IsExist(err) {
 		// It exists. Because of O_EXCL, we haven't actually opened the
 		// file. Just verify that protoc works and return.
-		if err := verifyProtocBinary(dstPath, version); err != nil {
+		if err := verifyProtocBinary(logger, dstPath, version); err != nil {
 			return "", err
 		}
 		return dstPath, nil
@@ -134,8 +138,8 @@ func CheckOrDownloadProtoc(path, version string) (string, error) {
 		if err := dstFile.Close(); err != nil {
 			return "", err
 		}
-		log.Verbosef("downloaded protoc to %s", dstPath)
-		if err := verifyProtocBinary(dstPath, version); err != nil {
+		logger.Verbosef("downloaded protoc to %s", dstPath)
+		if err := verifyProtocBinary(logger, dstPath, version); err != nil {
 			return "", err
 		}
 		return dstPath, nil
@@ -143,20 +147,11 @@ func CheckOrDownloadProtoc(path, version string) (string, error) {
 	return "", fmt.Errorf("unable to download and extract protoc")
 }
 
-func verifyProtocBinary(path, version string) error {
-	cmd := log.ExecCommand(path, "--version")
-	out, err := cmd.Output()
+func verifyProtocBinary(logger *log.Logger, path, version string) error {
+	gotVersion, err := ProtocVersion(logger, path)
 	if err != nil {
-		return log.ExecError(path, err)
-	}
-	versionOutput := string(out)
-	if versionOutput[:9] != "libprotoc" {
-		return fmt.Errorf("%q was not a valid protoc binary", path)
+		return err
 	}
-	// NOTE: the output of protoc --version doesn't include a 'v',
-	// but the release tags do
-	gotVersion := strings.TrimSpace(versionOutput[10:])
-	gotVersion = "v" + gotVersion
 	// split "-rc"
 	split := strings.Split(version, "-")
 	if gotVersion != split[0] {
@@ -165,6 +160,42 @@ func verifyProtocBinary(path, version string) error {
 	return nil
 }
 
+// ProtocVersion returns the version of the protoc binary at path, as reported
+// by `protoc --version`, with a "v" prefix like the release tags.
+func ProtocVersion(logger *log.Logger, path string) (string, error) {
+	cmd := logger.CommandContext(context.Background(), path, "--version")
+	out, err := logger.Output(cmd)
+	if err != nil {
+		return "", log.ExecError(path, err)
+	}
+	versionOutput := string(out)
+	if !strings.HasPrefix(versionOutput, "libprotoc ") {
+		return "", fmt.Errorf("%q was not a valid protoc binary", path)
+	}
+	// NOTE: the output of protoc --version doesn't include a 'v',
+	// but the release tags do
+	return "v" + strings.TrimSpace(versionOutput[10:]), nil
+}
+
+// SupportsProto3Optional returns whether a protoc version supports proto3
+// optional fields, which were added in v3.15.0. Since v21.0, the versions of
+// protoc no longer start with the major version 3.
+func SupportsProto3Optional(version string) bool {
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	return major > 3 || major == 3 && minor >= 15
+}
+
 // protocDownloadURL builds a URL for retrieving for the protoc tool artifact
 // from GitHub for use with current Go runtime's GOOS and GOARCH combination.
 //
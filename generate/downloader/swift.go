@@ -2,6 +2,7 @@ package downloader
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os/exec"
 	"path/filepath"
@@ -16,7 +17,7 @@ func (g Swift) Name() string {
 	return "swift"
 }
 
-func (g Swift) Download(version string, p Paths) (string, error) {
+func (g Swift) Download(logger *log.Logger, version string, p Paths) (string, error) {
 	version = strings.TrimPrefix(version, "v")
 	if _, err := exec.LookPath("swift"); err != nil {
 		return "", fmt.Errorf("swift is not installed, see https://swift.org/download/")
@@ -24,17 +25,17 @@ func (g Swift) Download(version string, p Paths) (string, error) {
 	repoPath := `https://github.com/apple/swift-protobuf`
 	binaryPath := filepath.Join(p.buildDir, ".build", "release", "protoc-gen-swift")
 	cmdArgs := []string{"clone", "--depth", "1", "--branch", version, repoPath, p.buildDir}
-	gitCmd := log.ExecCommand("git", cmdArgs...)
-	err := gitCmd.Run()
+	gitCmd := logger.CommandContext(context.Background(), "git", cmdArgs...)
+	err := logger.Run(gitCmd)
 	if err != nil {
 		all := "git " + strings.Join(cmdArgs, " ")
 		return "", log.ExecError(all, err)
 	}
-	buildCmd := log.ExecCommand("swift", "build", "-c", "release")
+	buildCmd := logger.CommandContext(context.Background(), "swift", "build", "-c", "release")
 	buildCmd.Dir = p.buildDir
 	var stderr bytes.Buffer
 	buildCmd.Stderr = &stderr
-	err = buildCmd.Run()
+	err = logger.Run(buildCmd)
 	if err != nil {
 		all := "swift build -c release"
 		return "", log.ExecError(all, err)
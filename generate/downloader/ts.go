@@ -1,6 +1,7 @@
 package downloader
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -22,7 +23,7 @@ func (g Ts) Name() string {
 	return g.ID
 }
 
-func (g Ts) Download(version string, p Paths) (string, error) {
+func (g Ts) Download(logger *log.Logger, version string, p Paths) (string, error) {
 	version = strings.TrimPrefix(version, "v")
 	if _, err := exec.LookPath("npm"); err != nil {
 		return "", fmt.Errorf("node is not installed. See https://nodejs.org/en/download/")
@@ -30,16 +31,16 @@ func (g Ts) Download(version string, p Paths) (string, error) {
 	if err := os.MkdirAll(p.buildDir, 0o755); err != nil {
 		return "", err
 	}
-	npmCmd := log.ExecCommand("npm", "init", "-y")
+	npmCmd := logger.CommandContext(context.Background(), "npm", "init", "-y")
 	npmCmd.Dir = p.buildDir
-	err := npmCmd.Run()
+	err := logger.Run(npmCmd)
 	if err != nil {
 		all := "npm init -y"
 		return "", log.ExecError(all, err)
 	}
-	npmCmd = log.ExecCommand("npm", "install", g.ModuleName + "@" + version)
+	npmCmd = logger.CommandContext(context.Background(), "npm", "install", g.ModuleName + "@" + version)
 	npmCmd.Dir = p.buildDir
-	err = npmCmd.Run()
+	err = logger.Run(npmCmd)
 	if err != nil {
 		all := "npm install " + g.ModuleName + "@" + version
 		return "", log.ExecError(all, err)
@@ -61,9 +62,9 @@ func (g Ts) Download(version string, p Paths) (string, error) {
 	for k, v := range protocJSON.Dependencies {
 		if strings.HasPrefix(v, "^") {
 			vv := strings.TrimPrefix(v, "^")
-			npmCmd := log.ExecCommand("npm", "install", fmt.Sprintf("%s@%s", k, vv))
+			npmCmd := logger.CommandContext(context.Background(), "npm", "install", fmt.Sprintf("%s@%s", k, vv))
 			npmCmd.Dir = p.buildDir
-			err := npmCmd.Run()
+			err := logger.Run(npmCmd)
 			if err != nil {
 				all := "npm install " + fmt.Sprintf("%s@%s", k, vv)
 				return "", log.ExecError(all, err)
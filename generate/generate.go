@@ -2,20 +2,28 @@ package generate
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/constant"
 	"go/token"
 	"go/types"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/protoc-gen-openapiv2/options"
+	"github.com/kenshaw/snaker"
 	"github.com/xo/ecosystem/proto/xo"
 	"google.golang.org/genproto/googleapis/api/annotations"
 
@@ -25,25 +33,57 @@ import (
 	"github.com/gunk/gunk/log"
 	"github.com/gunk/gunk/protoutil"
 	"github.com/gunk/gunk/reflectutil"
-	"github.com/karelbilek/dirchanges"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/pluginpb"
 )
 
 // Run generates the specified Gunk packages via protobuf generators, writing
-// the output files in the same directories.
+// the output files in the same directories. Errors are logged to standard
+// error. Use New to set other options.
 func Run(dir string, args ...string) error {
-	g := NewGenerator(dir)
-	// Check that protoc exists, if not download it.
+	return New(Options{Dir: dir, Logger: stderrLogger()}).Run(context.Background(), args...)
+}
+
+// Run generates the specified Gunk packages like the Run function, with the
+// options of the Generator.
+func (g *Generator) Run(ctx context.Context, args ...string) error {
+	return g.generate(ctx, args...)
+}
+
+// Check generates the specified Gunk packages like Run, but instead of
+// writing the output files, it compares them to the files on disk. A unified
+// diff of the files that differ is written to w, and an error listing them is
+// returned.
+func Check(w io.Writer, dir string, args ...string) error {
+	return New(Options{Dir: dir, Logger: stderrLogger()}).Check(context.Background(), w, args...)
+}
+
+// Check checks the generated files of the specified Gunk packages like the
+// Check function, with the options of the Generator.
+func (g *Generator) Check(ctx context.Context, w io.Writer, args ...string) error {
+	g.check = true
+	if err := g.generate(ctx, args...); err != nil {
+		return err
+	}
+	return g.reportStale(w)
+}
+
+// generate loads, translates and generates the specified Gunk packages.
+func (g *Generator) generate(ctx context.Context, args ...string) error {
 	pkgs, err := g.Load(args...)
 	if err != nil {
 		return fmt.Errorf("error loading packages: %w", err)
 	}
+	if g.cache == nil {
+		if g.cache, err = openCache(); err != nil {
+			return fmt.Errorf("unable to open generate cache: %w", err)
+		}
+	}
 	if len(pkgs) == 0 {
 		return fmt.Errorf("no Gunk packages to generate")
 	}
-	if loader.PrintErrors(pkgs) > 0 {
+	if g.printErrors(pkgs) > 0 {
 		return fmt.Errorf("encountered package loading errors")
 	}
 	// Record the loaded packages in gunkPkgs.
@@ -53,46 +93,56 @@ func Run(dir string, args ...string) error {
 	// Translate the packages from Gunk to Proto.
 	for _, pkg := range pkgs {
 		cfg, err := config.Load(pkg.Dir)
+		if g.generators != nil && errors.Is(err, config.ErrNotFound) {
+			// The generators are given, so the config is
+			// optional.
+			cfg, err = &config.Config{Dir: pkg.Dir}, nil
+		}
 		if err != nil {
 			return fmt.Errorf("unable to load gunkconfig: %w", err)
 		}
+		if g.generators != nil {
+			cfg.Generators = g.generators
+		}
 		pkgConfigs[pkg.Dir] = cfg
 		if err := g.translatePkg(pkg.PkgPath); err != nil {
 			return fmt.Errorf("unable to translate pkg: %w", err)
 		}
 	}
-	// hack: take protoc config from the first package
-	firstPkg := pkgs[0]
-	cfg := pkgConfigs[firstPkg.Dir]
-	protocPath, err := downloader.CheckOrDownloadProtoc(cfg.ProtocPath, cfg.ProtocVersion)
-	if err != nil {
-		return fmt.Errorf("unable to check or download protoc: %w", err)
-	}
-	g.protoLoader.ProtocPath = protocPath
 	// Load any non-Gunk proto dependencies.
 	if err := g.loadProtoDeps(); err != nil {
 		return fmt.Errorf("unable to load protodeps: %w", err)
 	}
+	// Set the custom options, now that their extensions are loaded.
+	if err := g.applyCustomOptions(); err != nil {
+		return fmt.Errorf("unable to set custom options: %w", err)
+	}
 	// Run the code generators.
-	protocPaths := make(map[string]string, len(pkgs))
 	pkgGens := make(map[string][]config.Generator, len(pkgs))
+	pkgProtocs := make(map[string]protocConfig, len(pkgs))
 	pkgPaths := make([]string, 0, len(pkgs))
 	for _, pkg := range pkgs {
 		cfg := pkgConfigs[pkg.Dir]
-		protocPath, err := downloader.CheckOrDownloadProtoc(cfg.ProtocPath, cfg.ProtocVersion)
-		if err != nil {
-			return fmt.Errorf("unable to check or download protoc: %w", err)
-		}
-		protocPaths[pkg.PkgPath] = protocPath
 		pkgGens[pkg.PkgPath] = cfg.Generators
+		pkgProtocs[pkg.PkgPath] = protocConfig{path: cfg.ProtocPath, version: cfg.ProtocVersion}
 		pkgPaths = append(pkgPaths, pkg.PkgPath)
 	}
-	if err := g.GeneratePkgs(pkgPaths, pkgGens, protocPaths); err != nil {
+	protocPaths, err := g.resolveProtocs(pkgPaths, pkgGens, pkgProtocs)
+	if err != nil {
+		return err
+	}
+	if err := g.generatePkgs(ctx, pkgPaths, pkgGens, protocPaths); err != nil {
 		return err
 	}
 	return nil
 }
 
+// printErrors logs the errors of the packages with g.Logger, or adds them to
+// g.Diagnostics if set, and returns how many there are.
+func (g *Generator) printErrors(pkgs []*loader.GunkPackage) int {
+	return g.ReportErrors(g.Logger.ErrorWriter(), pkgs)
+}
+
 // FileDescriptorSet will load a single Gunk package, and return the
 // proto FileDescriptor set of the Gunk package.
 //
@@ -122,12 +172,57 @@ func FileDescriptorSet(dir string, args ...string) (*descriptorpb.FileDescriptor
 	if err := g.loadProtoDeps(); err != nil {
 		return nil, err
 	}
+	if err := g.applyCustomOptions(); err != nil {
+		return nil, err
+	}
 	// Generate the filedescriptorset for the Gunk package.
 	req := g.newCodeGenRequest(pkgs[0].PkgPath)
 	fds := &descriptorpb.FileDescriptorSet{File: req.ProtoFile}
 	return fds, nil
 }
 
+// ProtoFiles loads the specified Gunk packages, and returns the proto files
+// they translate into, in the same order as the packages. All the other proto
+// files they depend on are returned as deps, in no particular order.
+func ProtoFiles(dir string, args ...string) (files, deps []*descriptorpb.FileDescriptorProto, err error) {
+	g := NewGenerator(dir)
+	pkgs, err := g.Load(args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pkgs) == 0 {
+		return nil, nil, fmt.Errorf("no Gunk packages to export")
+	}
+	if loader.PrintErrors(pkgs) > 0 {
+		return nil, nil, fmt.Errorf("encountered package loading errors")
+	}
+	g.recordPkgs(pkgs...)
+	for _, pkg := range pkgs {
+		if err := g.translatePkg(pkg.PkgPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	// The dependencies are needed to set custom options.
+	if err := g.loadProtoDeps(); err != nil {
+		return nil, nil, err
+	}
+	if err := g.applyCustomOptions(); err != nil {
+		return nil, nil, err
+	}
+	exported := make(map[string]bool, len(pkgs))
+	for _, pkg := range pkgs {
+		name := unifiedProtoFile(pkg.PkgPath)
+		exported[name] = true
+		files = append(files, g.allProto[name])
+	}
+	for name, pfile := range g.allProto {
+		if !exported[name] {
+			deps = append(deps, pfile)
+		}
+	}
+	return files, deps, nil
+}
+
 // NewGenerator returns an initialized Generator with the provided dir.
 func NewGenerator(dir string) *Generator {
 	return &Generator{
@@ -138,17 +233,33 @@ func NewGenerator(dir string) *Generator {
 		},
 		gunkPkgs:    make(map[string]*loader.GunkPackage),
 		ignoredGen:  make(map[string]ignored),
+		nestedIn:    make(map[string]map[string]nestedDecl),
 		allProto:    make(map[string]*descriptorpb.FileDescriptorProto),
+		outputs:     make(map[string][]manifestEntry),
 		protoLoader: &loader.ProtoLoader{},
 	}
 }
 
 type Generator struct {
 	loader.Loader
+	// Jobs is the maximum number of code generators to run concurrently.
+	// If zero or negative, GOMAXPROCS is used.
+	Jobs int
+	// check makes the generators compare their output files to the
+	// files on disk instead of writing them. See reportStale.
+	check bool
+
+	// generators, if not nil, are run for all packages instead of the
+	// generators in their .gunkconfig files.
+	generators []config.Generator
+	// opts are the options the Generator was created with by New.
+	opts Options
+
 	curPkg    *loader.GunkPackage               // current package being translated or generated
 	curPos    token.Pos                         // current position of the token being evaluated
 	curIgnore ignored                           // current entries for items being ignored
 	gfile     *ast.File                         // current Go file being translated
+	prevEnd   token.Pos                         // end of the previous declaration, to find detached comments
 	pfile     *descriptorpb.FileDescriptorProto // current protobuf file being translated into
 
 	usedImports map[string]bool // imports being used for the current package
@@ -156,17 +267,46 @@ type Generator struct {
 	gunkPkgs map[string]*loader.GunkPackage
 	// Maps package import path to ignored items by proto name
 	ignoredGen map[string]ignored
+	// Maps package import path to the types declared as nested in another
+	// message, keyed by Gunk type name.
+	nestedIn map[string]map[string]nestedDecl
 	// imported proto files will be loaded using protoLoader
 	// holds the absolute path passed to -I flag from protoc
 	protoLoader *loader.ProtoLoader
 	// All protobuf that has been translated currently.
 	allProto map[string]*descriptorpb.FileDescriptorProto
+	// Files written by the generators, and the generated files which
+	// differ from the ones on disk with Check.
+	outputsMu sync.Mutex
+	outputs   map[string][]manifestEntry // by package path
+	stale     []staleFile
+	// single is set when generate_single generators ran, as their files
+	// aren't recorded in any manifest.
+	single bool
+	// collected holds the generated files by path, when they are written
+	// to an Output instead of the source tree.
+	collected map[string][]byte
+	// cache holds the outputs of previous generator runs. If nil, the
+	// generators are always run.
+	cache *genCache
+	// protocVersions holds the versions of the protoc binaries, by path.
+	protocVersionsMu sync.Mutex
+	protocVersions   map[string]string
+	// Custom options to set once their proto extensions are loaded.
+	customOpts []customOption
 	// Next indexes to use for message, service and enum.
 	messageIndex int32
 	serviceIndex int32
 	enumIndex    int32
 }
 
+// nestedDecl is a message or enum declared as nested in another message, using
+// the message.NestedIn or enum.NestedIn annotations.
+type nestedDecl struct {
+	parent string    // Gunk name of the parent message
+	pos    token.Pos // position of the nested type declaration
+}
+
 type ignored struct {
 	messages map[string]*ignoredEntry // child item is fields
 	enums    map[string]*ignoredEntry // child item is enum values
@@ -222,6 +362,26 @@ func (g *Generator) recordPkgs(pkgs ...*loader.GunkPackage) {
 				}
 			}
 		}
+		// capture message.NestedIn and enum.NestedIn annotations
+		for node, tags := range pkg.GunkTags {
+			tspec, ok := node.(*ast.TypeSpec)
+			if !ok {
+				continue
+			}
+			for _, tag := range tags {
+				switch tag.Type.String() {
+				case "github.com/gunk/opt/message.NestedIn",
+					"github.com/gunk/opt/enum.NestedIn":
+					if g.nestedIn[pkg.PkgPath] == nil {
+						g.nestedIn[pkg.PkgPath] = make(map[string]nestedDecl)
+					}
+					g.nestedIn[pkg.PkgPath][tspec.Name.Name] = nestedDecl{
+						parent: constant.StringVal(tag.Value),
+						pos:    tspec.Pos(),
+					}
+				}
+			}
+		}
 		g.gunkPkgs[pkg.PkgPath] = pkg
 		for _, ipkg := range pkg.Imports {
 			g.recordPkgs(ipkg)
@@ -281,37 +441,12 @@ func (g *Generator) GeneratePkg(path string, gens []config.Generator, protocPath
 // Generated files are written to the same directory, next to the source gunk
 // files.
 func (g *Generator) GeneratePkgs(paths []string, gens map[string][]config.Generator, protocPath map[string]string) error {
-	run := func(req *pluginpb.CodeGeneratorRequest, generators []config.Generator, path string) error {
-		for _, gen := range generators {
-			pruned := g.pruneIgnored(req, gen)
-			switch {
-			case gen.IsProtoc():
-				if gen.PluginVersion != "" {
-					return fmt.Errorf("cannot use pinned version with protoc option")
-				}
-				if err := g.generateProtoc(pruned, gen, protocPath[path]); err != nil {
-					return fmt.Errorf("unable to generate protoc: %w", err)
-				}
-			default:
-				c := configWithBinary{Generator: gen}
-				if gen.PluginVersion != "" {
-					has := downloader.Has(gen.Code())
-					if !has {
-						return fmt.Errorf("plugin %s does not support pinned versions", gen.Code())
-					}
-					bin, err := downloader.Download(gen.Code(), gen.PluginVersion)
-					if err != nil {
-						return err
-					}
-					c.binary = &bin
-				}
-				if err := g.generatePlugin(pruned, c); err != nil {
-					return fmt.Errorf("unable to generate plugin: %w", err)
-				}
-			}
-		}
-		return nil
-	}
+	return g.generatePkgs(context.Background(), paths, gens, protocPath)
+}
+
+// generatePkgs is GeneratePkgs with a context, which kills the generators
+// still running when it's done.
+func (g *Generator) generatePkgs(ctx context.Context, paths []string, gens map[string][]config.Generator, protocPath map[string]string) error {
 	type generatorWithFiles struct {
 		generator config.Generator
 		files     []string
@@ -336,60 +471,209 @@ func (g *Generator) GeneratePkgs(paths []string, gens map[string][]config.Genera
 			if len(singleFile.files) != 0 {
 				continue
 			}
-			configAbs, err := filepath.Abs(gen.ConfigDir)
+			files, err := g.singlePkgs(gen, paths)
 			if err != nil {
-				return fmt.Errorf(
-					"unable to get absolute directory of config path %q: %w",
-					gen.ConfigDir, err,
-				)
-			}
-			for _, path := range paths {
-				pathAbs, err := filepath.Abs(g.gunkPkgs[path].Dir)
-				if err != nil {
-					return fmt.Errorf(
-						"unable to get absolute directory of file path %q: %w",
-						path, err,
-					)
-				}
-				if strings.HasPrefix(pathAbs, configAbs) {
-					singleFile.files = append(singleFile.files, path)
-				}
+				return err
 			}
+			singleFile.files = append(singleFile.files, files...)
 			singleFiles = append(singleFiles, singleFile)
 		}
 	}
+	// Every generator run is independent of the others, so they are all
+	// queued up as jobs to be run concurrently. The generate_single ones
+	// go first, as they did when generators were run one at a time.
+	var jobs []*generateJob
+	singleErr := func(gen config.Generator, files []string, err error) error {
+		return fmt.Errorf(
+			"unable to generate_single on %s:\n\tFiles: %v\n\tError: %w",
+			gen.Command, files, err,
+		)
+	}
 	for _, v := range singleFiles {
-		log.Verbosef("generator %s", v.generator.Command)
+		g.Logger.Verbosef("generator %s", v.generator.Command)
 		req := g.newCodeGenRequest(v.files...)
-		generators := []config.Generator{v.generator}
-		if err := run(req, generators, ""); err != nil {
-			return fmt.Errorf(
-				"unable to generate_single on %s:\n\tFiles: %v\n\tError: %w",
-				v.generator.Command, v.files, err,
-			)
+		var singleProtoc string
+		if v.generator.IsProtoc() {
+			var err error
+			if singleProtoc, err = singleProtocPath(v.files, protocPath); err != nil {
+				return singleErr(v.generator, v.files, err)
+			}
 		}
+		job, err := g.newGenerateJob(req, v.generator, singleProtoc)
+		if err != nil {
+			return singleErr(v.generator, v.files, err)
+		}
+		job.files = v.files
+		jobs = append(jobs, job)
+		g.single = true
 	}
 	for _, path := range paths {
-		log.Verbosef("%s", path)
+		g.Logger.Verbosef("%s", path)
 		// It is fine to pass the pluginpb.CodeGeneratorRequest to every protoc
 		// generator unaltered; this is what protoc does when calling out to the
 		// generators and the generators should already handle the case where they
 		// have nothing to do.
 		req := g.newCodeGenRequest(path)
-		generators := make([]config.Generator, 0, len(gens[path]))
 		for _, gen := range gens[path] {
 			if gen.Single {
 				continue
 			}
-			generators = append(generators, gen)
+			job, err := g.newGenerateJob(req, gen, protocPath[path])
+			if err != nil {
+				return fmt.Errorf("unable to generate pkg %s: %w", path, err)
+			}
+			job.pkgPath = path
+			jobs = append(jobs, job)
 		}
-		if err := run(req, generators, path); err != nil {
-			return fmt.Errorf("unable to generate pkg %s: %w", path, err)
+	}
+	g.runJobs(ctx, jobs)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Report the error of the first failed job, so that it doesn't depend
+	// on the order in which the jobs happened to finish.
+	for _, job := range jobs {
+		switch {
+		case job.err == nil:
+		case job.files != nil:
+			return singleErr(job.gen.Generator, job.files, job.err)
+		default:
+			return fmt.Errorf("unable to generate pkg %s: %w", job.pkgPath, job.err)
+		}
+	}
+	// Now that all generators have run, remove the files they no longer
+	// generate.
+	for _, path := range paths {
+		if err := g.updateManifest(g.gunkPkgs[path]); err != nil {
+			return fmt.Errorf("unable to update manifest of pkg %s: %w", path, err)
 		}
 	}
 	return nil
 }
 
+// singlePkgs returns the packages among paths that the generate_single
+// generator gen runs on: those in or under the directory of its config.
+func (g *Generator) singlePkgs(gen config.Generator, paths []string) ([]string, error) {
+	configAbs, err := filepath.Abs(gen.ConfigDir)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"unable to get absolute directory of config path %q: %w",
+			gen.ConfigDir, err,
+		)
+	}
+	var files []string
+	for _, path := range paths {
+		pathAbs, err := filepath.Abs(g.gunkPkgs[path].Dir)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"unable to get absolute directory of file path %q: %w",
+				path, err,
+			)
+		}
+		if strings.HasPrefix(pathAbs, configAbs) {
+			files = append(files, path)
+		}
+	}
+	return files, nil
+}
+
+// generateJob is a single run of a code generator, for either a package or
+// the files of a generate_single generator.
+type generateJob struct {
+	req        *pluginpb.CodeGeneratorRequest
+	gen        configWithBinary
+	protocPath string
+	pkgPath    string   // package being generated
+	files      []string // packages being generated with generate_single
+
+	err error // result of running the job
+}
+
+// newGenerateJob prepares a run of gen with the request, downloading the
+// generator first if it is pinned to a version.
+func (g *Generator) newGenerateJob(req *pluginpb.CodeGeneratorRequest, gen config.Generator, protocPath string) (*generateJob, error) {
+	job := &generateJob{
+		req:        g.pruneIgnored(req, gen),
+		gen:        configWithBinary{Generator: gen},
+		protocPath: protocPath,
+	}
+	if gen.PluginVersion == "" {
+		return job, nil
+	}
+	if gen.IsProtoc() {
+		return nil, fmt.Errorf("cannot use pinned version with protoc option")
+	}
+	if !downloader.Has(gen.Code()) {
+		return nil, fmt.Errorf("plugin %s does not support pinned versions", gen.Code())
+	}
+	bin, err := downloader.Download(g.Logger, gen.Code(), gen.PluginVersion)
+	if err != nil {
+		return nil, err
+	}
+	job.gen.binary = &bin
+	return job, nil
+}
+
+// runJobs runs the jobs in order with at most g.Jobs running at once, setting
+// the error of each job. Once a job has failed, the jobs that haven't started
+// yet are skipped. As jobs are started in order, the first job to fail is
+// always run. Once ctx is done, the jobs still running are killed, and the
+// others fail with its error.
+func (g *Generator) runJobs(ctx context.Context, jobs []*generateJob) {
+	workers := g.Jobs
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	var (
+		wg     sync.WaitGroup
+		failed int32
+	)
+	queue := make(chan *generateJob)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if atomic.LoadInt32(&failed) != 0 {
+					job.err = errSkipped
+					continue
+				}
+				if err := ctx.Err(); err != nil {
+					job.err = err
+					atomic.StoreInt32(&failed, 1)
+					continue
+				}
+				if job.err = g.runJob(ctx, job); job.err != nil {
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	for _, job := range jobs {
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+}
+
+// errSkipped is the error of jobs skipped because an earlier job failed. It is
+// never reported, as the earlier job's error always is.
+var errSkipped = errors.New("skipped after an earlier error")
+
+// runJob runs the job's generator.
+func (g *Generator) runJob(ctx context.Context, job *generateJob) error {
+	if job.gen.IsProtoc() {
+		if err := g.generateProtoc(ctx, job.req, job.gen.Generator, job.protocPath); err != nil {
+			return fmt.Errorf("unable to generate protoc: %w", err)
+		}
+		return nil
+	}
+	if err := g.generatePlugin(ctx, job.req, job.gen); err != nil {
+		return fmt.Errorf("unable to generate plugin: %w", err)
+	}
+	return nil
+}
+
 func (g *Generator) pruneIgnored(old *pluginpb.CodeGeneratorRequest, gen config.Generator) *pluginpb.CodeGeneratorRequest {
 	if gen.Command == "" {
 		return old
@@ -443,12 +727,17 @@ func (g *Generator) pruneIgnored(old *pluginpb.CodeGeneratorRequest, gen config.
 
 				msg.Field = append(msg.Field, field)
 			}
+			pruneOneofs(&msg)
 
 			// insert source code info
 			if loc := parsed.msg[i]; loc != nil {
 				loc.Path = []int32{messagePath, int32(len(fdp.MessageType))}
 				fdp.SourceCodeInfo.Location = append(fdp.SourceCodeInfo.Location, loc)
 			}
+			for _, loc := range parsed.msgOther[i] {
+				loc.Path = append([]int32{messagePath, int32(len(fdp.MessageType))}, loc.Path[2:]...)
+				fdp.SourceCodeInfo.Location = append(fdp.SourceCodeInfo.Location, loc)
+			}
 
 			fdp.MessageType = append(fdp.MessageType, &msg)
 		}
@@ -523,6 +812,39 @@ func (g *Generator) pruneIgnored(old *pluginpb.CodeGeneratorRequest, gen config.
 	return &req
 }
 
+// pruneOneofs removes the oneofs of a message which no longer have any
+// fields after pruning, updating the oneof indexes of the remaining fields.
+func pruneOneofs(msg *descriptorpb.DescriptorProto) {
+	used := make([]bool, len(msg.OneofDecl))
+	for _, field := range msg.Field {
+		if field.OneofIndex != nil {
+			used[field.GetOneofIndex()] = true
+		}
+	}
+	newIndex := make([]int32, len(msg.OneofDecl))
+	oneofs := make([]*descriptorpb.OneofDescriptorProto, 0, len(msg.OneofDecl))
+	for i, oneof := range msg.OneofDecl {
+		if used[i] {
+			newIndex[i] = int32(len(oneofs))
+			oneofs = append(oneofs, oneof)
+		}
+	}
+	if len(oneofs) == len(msg.OneofDecl) {
+		// Nothing was pruned.
+		return
+	}
+	msg.OneofDecl = oneofs
+	for i, field := range msg.Field {
+		if field.OneofIndex == nil {
+			continue
+		}
+		// Make a copy, to not modify the field for other generators.
+		field = proto.Clone(field).(*descriptorpb.FieldDescriptorProto)
+		field.OneofIndex = proto.Int32(newIndex[field.GetOneofIndex()])
+		msg.Field[i] = field
+	}
+}
+
 func parseSrcInfo(fdp *descriptorpb.FileDescriptorProto) (*descriptorpb.SourceCodeInfo, sourceCodeLocations) {
 	newInfo := &descriptorpb.SourceCodeInfo{
 		Location: make([]*descriptorpb.SourceCodeInfo_Location, 0, len(fdp.SourceCodeInfo.Location)),
@@ -533,6 +855,7 @@ func parseSrcInfo(fdp *descriptorpb.FileDescriptorProto) (*descriptorpb.SourceCo
 		svc:  make([]*descriptorpb.SourceCodeInfo_Location, len(fdp.Service)),
 
 		msgFields:  make([][]*descriptorpb.SourceCodeInfo_Location, len(fdp.MessageType)),
+		msgOther:   make([][]*descriptorpb.SourceCodeInfo_Location, len(fdp.MessageType)),
 		enumVals:   make([][]*descriptorpb.SourceCodeInfo_Location, len(fdp.EnumType)),
 		svcMethods: make([][]*descriptorpb.SourceCodeInfo_Location, len(fdp.Service)),
 	}
@@ -565,14 +888,22 @@ func parseSrcInfo(fdp *descriptorpb.FileDescriptorProto) (*descriptorpb.SourceCo
 		if len(loc.Path) == 4 {
 			idx := int(loc.Path[1])
 			fieldIdx := int(loc.Path[3])
-			switch loc.Path[0] {
-			case messagePath:
+			switch {
+			case loc.Path[0] == messagePath && loc.Path[2] == messageFieldPath:
 				locs.msgFields[idx][fieldIdx] = loc
-			case enumPath:
+				continue
+			case loc.Path[0] == enumPath && loc.Path[2] == enumValuePath:
 				locs.enumVals[idx][fieldIdx] = loc
-			case servicePath:
+				continue
+			case loc.Path[0] == servicePath && loc.Path[2] == serviceMethodPath:
 				locs.svcMethods[idx][fieldIdx] = loc
+				continue
 			}
+		}
+		if len(loc.Path) > 2 && loc.Path[0] == messagePath {
+			// Other locations within a message, such as nested types.
+			idx := int(loc.Path[1])
+			locs.msgOther[idx] = append(locs.msgOther[idx], loc)
 			continue
 		}
 		newInfo.Location = append(newInfo.Location, loc)
@@ -586,6 +917,7 @@ type sourceCodeLocations struct {
 	svc  []*descriptorpb.SourceCodeInfo_Location
 
 	msgFields  [][]*descriptorpb.SourceCodeInfo_Location
+	msgOther   [][]*descriptorpb.SourceCodeInfo_Location
 	enumVals   [][]*descriptorpb.SourceCodeInfo_Location
 	svcMethods [][]*descriptorpb.SourceCodeInfo_Location
 }
@@ -593,7 +925,7 @@ type sourceCodeLocations struct {
 // generateProtoc invokes protoc to generate the package specified in the
 // CodeGeneratorRequest and applies post processing if applicable. It expects
 // exactly one file to be requested in CodeGeneratorRequest.
-func (g *Generator) generateProtoc(req *pluginpb.CodeGeneratorRequest, gen config.Generator, protocCommandPath string) error {
+func (g *Generator) generateProtoc(ctx context.Context, req *pluginpb.CodeGeneratorRequest, gen config.Generator, protocCommandPath string) error {
 	// Default location to output protoc generated files.
 	ftgs := req.GetFileToGenerate()
 	switch len(ftgs) {
@@ -604,6 +936,15 @@ func (g *Generator) generateProtoc(req *pluginpb.CodeGeneratorRequest, gen confi
 	default:
 		return fmt.Errorf("protoc can only be invoked one file at a time")
 	}
+	if hasProto3Optional(req) {
+		version, err := g.protocVersion(protocCommandPath)
+		if err != nil {
+			return err
+		}
+		if !downloader.SupportsProto3Optional(version) {
+			return fmt.Errorf("protoc %s does not support proto3 optional fields, v3.15.0 or later is required", version)
+		}
+	}
 	// req.GetFileToGenerate() is always just 1 field, as we create the request
 	// and it has just the one proto file
 	ftg := ftgs[0]
@@ -620,8 +961,8 @@ func (g *Generator) generateProtoc(req *pluginpb.CodeGeneratorRequest, gen confi
 	copy(fds.File, req.ProtoFile)
 	// protoc writes the output files directly, unlike the
 	// protoc-gen-* plugin generators.
-	// As such, we need to give it the right basenames and output
-	// directory, so that it writes the files in the right place.
+	// As such, we need to give it the right basenames, so that it
+	// writes the files with the right names.
 	for i, pf := range fds.File {
 		if pf.GetName() == ftg {
 			// Make a copy, to not modify the files for
@@ -643,65 +984,60 @@ func (g *Generator) generateProtoc(req *pluginpb.CodeGeneratorRequest, gen confi
 		return fmt.Errorf("cannot marshal deterministically: %w", err)
 	}
 	// output dir
-	protocOutputPath := gpkg.Dir
-	outDir, err := outPath(gen, protocOutputPath, mainPkg.Name)
+	outDir, err := outPath(gen, gpkg.Dir, mainPkg.Name)
 	if err != nil {
 		return fmt.Errorf("unable to build output path for %q: %w", gpkg.Dir, err)
 	}
-	if outDir != "" {
-		if err := mkdirAll(outDir); err != nil {
-			return fmt.Errorf("unable to create directory %q: %w", outDir, err)
-		}
-	}
-	// Build up the protoc command line arguments.
-	param := paramStringWithOut(gen, outDir)
-	args := []string{
-		fmt.Sprintf("--%s_out=%s", gen.ProtocGen, param),
-		"--descriptor_set_in=/dev/stdin",
-		basename,
-	}
-	var d *dirchanges.Watcher
-	// if we have postproc - try to watch for new files (ignore otherwise)
-	// unfortunately, protoc gives us no hint of what files it generated
-	// so we look for FS changes
-	if gen.HasPostproc() {
-		d = dirchanges.New()
-		if err := d.AddRecursive(protocOutputPath); err != nil {
-			return err
+	// protoc is told to write to a temporary directory, so that we know
+	// which files it generated. They can then be cached, post processed
+	// and moved to outDir, like the output of plugin generators.
+	input := append([]byte(fmt.Sprintf("%s\n%s\n%s\n", gen.ProtocGen, gen.ParamString(), basename)), buf...)
+	resp, err := g.cache.run(protocCommandPath, input, func() (*pluginpb.CodeGeneratorResponse, error) {
+		tmpDir, err := ioutil.TempDir("", "gunk-protoc")
+		if err != nil {
+			return nil, err
 		}
-		d.FilterOps(dirchanges.Write, dirchanges.Move, dirchanges.Rename, dirchanges.Create)
-	}
-	cmd := log.ExecCommand(protocCommandPath, args...)
-	cmd.Stdin = bytes.NewReader(buf)
-	if _, err := cmd.Output(); err != nil {
-		// TODO: For now, output the command name directly as
-		// we actually use the /path/to/protoc when executing
-		// the command, but this gives slightly uglier error
-		// messages. Not sure what is best to do here, but
-		// it should be consistent with running protoc-gen-*
-		// errors (which currently don't use the /path/to/protoc-gen).
-		return log.ExecError("protoc", err)
-	}
-	if gen.HasPostproc() {
-		ev, err := d.Diff()
+		defer os.RemoveAll(tmpDir)
+		// Build up the protoc command line arguments.
+		args := []string{
+			fmt.Sprintf("--%s_out=%s", gen.ProtocGen, paramStringWithOut(gen, tmpDir)),
+			"--descriptor_set_in=/dev/stdin",
+			basename,
+		}
+		cmd := g.Logger.CommandContext(ctx, protocCommandPath, args...)
+		cmd.Stdin = bytes.NewReader(buf)
+		if _, err := g.Logger.Output(cmd); err != nil {
+			// TODO: For now, output the command name directly as
+			// we actually use the /path/to/protoc when executing
+			// the command, but this gives slightly uglier error
+			// messages. Not sure what is best to do here, but
+			// it should be consistent with running protoc-gen-*
+			// errors (which currently don't use the /path/to/protoc-gen).
+			return nil, log.ExecError("protoc", err)
+		}
+		files, err := readFiles(tmpDir)
 		if err != nil {
-			return fmt.Errorf("file diff error: %w", err)
+			return nil, fmt.Errorf("unable to read protoc output: %w", err)
 		}
-		for _, ev := range ev {
-			if !ev.IsDir() {
-				bs, err := ioutil.ReadFile(ev.Path)
-				if err != nil {
-					return fmt.Errorf("failed to read file from %s for post processing: %w", ev.Path, err)
-				}
-				var nbs []byte
-				if nbs, err = postProcess(bs, gen, mainPkgPath, g.gunkPkgs); err != nil {
-					return fmt.Errorf("failed to execute post processing: %w", err)
-				}
-				if err := ioutil.WriteFile(ev.Path, nbs, ev.Mode()); err != nil {
-					return fmt.Errorf("failed to write to file: %w", err)
-				}
+		return &pluginpb.CodeGeneratorResponse{File: files}, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, rf := range resp.File {
+		outPath := filepath.Join(outDir, filepath.FromSlash(rf.GetName()))
+		data := []byte(rf.GetContent())
+		if gen.HasPostproc() {
+			if data, err = g.postProcessFile(ctx, data, gen, mainPkgPath, outPath); err != nil {
+				return fmt.Errorf("failed to execute post processing: %w", err)
 			}
 		}
+		if err := g.mkdirAll(filepath.Dir(outPath)); err != nil {
+			return fmt.Errorf("unable to create directory %q: %w", filepath.Dir(outPath), err)
+		}
+		if err := g.writeFile(mainPkgPath, gen.Code(), outPath, data); err != nil {
+			return fmt.Errorf("unable to write to file %q: %w", outPath, err)
+		}
 	}
 	return nil
 }
@@ -709,7 +1045,7 @@ func (g *Generator) generateProtoc(req *pluginpb.CodeGeneratorRequest, gen confi
 // generatePlugin invokes the specified binary in the config with the package
 // requested in CodeGeneratorRequest. It expects exactly one file to be
 // requested in CodeGeneratorRequest.
-func (g *Generator) generatePlugin(req *pluginpb.CodeGeneratorRequest, gen configWithBinary) error {
+func (g *Generator) generatePlugin(ctx context.Context, req *pluginpb.CodeGeneratorRequest, gen configWithBinary) error {
 	// Due to problems with some generators (grpc-gateway),
 	// we need to ensure we either send a non-empty string or nil.
 	if ps := gen.ParamString(); ps == "" {
@@ -721,19 +1057,31 @@ func (g *Generator) generatePlugin(req *pluginpb.CodeGeneratorRequest, gen confi
 	if err != nil {
 		return fmt.Errorf("cannot marshal deterministically: %w", err)
 	}
-	cmd := log.ExecCommand(gen.actualCommand())
-	cmd.Stdin = bytes.NewReader(bs)
-	out, err := cmd.Output()
+	resp, err := g.cache.run(gen.actualCommand(), bs, func() (*pluginpb.CodeGeneratorResponse, error) {
+		cmd := g.Logger.CommandContext(ctx, gen.actualCommand())
+		cmd.Stdin = bytes.NewReader(bs)
+		out, err := g.Logger.Output(cmd)
+		if err != nil {
+			return nil, log.ExecError(gen.actualCommand(), err)
+		}
+		var resp pluginpb.CodeGeneratorResponse
+		if err = proto.Unmarshal(out, &resp); err != nil {
+			return nil, err
+		}
+		return &resp, nil
+	})
 	if err != nil {
-		return log.ExecError(gen.actualCommand(), err)
-	}
-	var resp pluginpb.CodeGeneratorResponse
-	if err = proto.Unmarshal(out, &resp); err != nil {
 		return err
 	}
 	if rerr := resp.GetError(); rerr != "" {
 		return fmt.Errorf("error from generator %s: %s", gen.Command, rerr)
 	}
+	// Like protoc, refuse to use the output of generators that haven't
+	// advertised support for proto3 optional fields if any are used.
+	supportsOptional := resp.GetSupportedFeatures()&uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL) != 0
+	if !supportsOptional && hasProto3Optional(req) {
+		return fmt.Errorf("generator %s does not support proto3 optional fields", gen.Command)
+	}
 	ftgs := req.GetFileToGenerate()
 	var outputPath, mainPkgName, mainPkgPath string
 	switch len(ftgs) {
@@ -796,16 +1144,6 @@ func (g *Generator) generatePlugin(req *pluginpb.CodeGeneratorRequest, gen confi
 			dir = outputPath
 		}
 
-		data := []byte(*rf.Content)
-		if gen.HasPostproc() {
-			if mainPkgPath == "" {
-				return fmt.Errorf("cannot run postprocessing in generate_single mode")
-			}
-			if data, err = postProcess(data, gen.Generator, mainPkgPath, g.gunkPkgs); err != nil {
-				return fmt.Errorf("failed to execute post processing: %w", err)
-			}
-		}
-
 		outPath := filepath.Join(dir, basename)
 
 		// remove fake path
@@ -816,20 +1154,71 @@ func (g *Generator) generatePlugin(req *pluginpb.CodeGeneratorRequest, gen confi
 			return fmt.Errorf("unable to build output path for %q: %w", outPath, err)
 		}
 
+		data := []byte(*rf.Content)
+		if gen.HasPostproc() {
+			if data, err = g.postProcessFile(ctx, data, gen.Generator, mainPkgPath, outPath); err != nil {
+				return fmt.Errorf("failed to execute post processing: %w", err)
+			}
+		}
+
 		// create path if not exists
 		if outDir, _ := filepath.Split(outPath); outDir != "" {
-			if err := mkdirAll(outDir); err != nil {
+			if err := g.mkdirAll(outDir); err != nil {
 				return fmt.Errorf("unable to create directory %q: %w", outDir, err)
 			}
 		}
 
-		if err := writeFile(outPath, data); err != nil {
+		if err := g.writeFile(mainPkgPath, gen.Code(), outPath, data); err != nil {
 			return fmt.Errorf("unable to write to file %q: %w", outPath, err)
 		}
 	}
 	return nil
 }
 
+// protocVersion returns the version of the protoc binary at path, running it
+// only once per path.
+func (g *Generator) protocVersion(path string) (string, error) {
+	g.protocVersionsMu.Lock()
+	defer g.protocVersionsMu.Unlock()
+	if version, ok := g.protocVersions[path]; ok {
+		return version, nil
+	}
+	version, err := downloader.ProtocVersion(g.Logger, path)
+	if err != nil {
+		return "", err
+	}
+	if g.protocVersions == nil {
+		g.protocVersions = make(map[string]string)
+	}
+	g.protocVersions[path] = version
+	return version, nil
+}
+
+// hasProto3Optional reports whether any of the files requested in
+// CodeGeneratorRequest use proto3 optional fields.
+func hasProto3Optional(req *pluginpb.CodeGeneratorRequest) bool {
+	var inMessages func(msgs []*descriptorpb.DescriptorProto) bool
+	inMessages = func(msgs []*descriptorpb.DescriptorProto) bool {
+		for _, msg := range msgs {
+			for _, field := range msg.Field {
+				if field.GetProto3Optional() {
+					return true
+				}
+			}
+			if inMessages(msg.NestedType) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, pfile := range req.ProtoFile {
+		if containsString(req.FileToGenerate, pfile.GetName()) && inMessages(pfile.MessageType) {
+			return true
+		}
+	}
+	return false
+}
+
 // newCodeGenRequest returns a CodeGeneratorRequest for the specified packages
 // which requests generation for the packages and specifies the dependencies of
 // the packages.
@@ -899,12 +1288,16 @@ func (g *Generator) translatePkg(pkgPath string) error {
 		// Already translated, e.g. as a dependency.
 		return nil
 	}
+	g.curPkg = gpkg
+	g.pfile = &descriptorpb.FileDescriptorProto{
+		Syntax: proto.String("proto3"),
+		Name:   proto.String(pfilename),
+	}
 	// Get file options for package
-	fo, err := fileOptions(gpkg)
+	fo, err := g.fileOptions(gpkg)
 	if err != nil {
 		return fmt.Errorf("unable to get file options: %v", err)
 	}
-	g.curPkg = gpkg
 	g.usedImports = make(map[string]bool)
 	g.curIgnore = ignored{
 		services: make(map[string]*ignoredEntry),
@@ -926,12 +1319,8 @@ func (g *Generator) translatePkg(pkgPath string) error {
 	// (package github.com/foo/bar can be "package foobar").
 	// We need to use "foobar", otherwise gunk will break
 	// (not matching package paths)
-	g.pfile = &descriptorpb.FileDescriptorProto{
-		Syntax:  proto.String("proto3"),
-		Name:    proto.String(pfilename),
-		Package: proto.String(gpkg.ProtoName),
-		Options: fo,
-	}
+	g.pfile.Package = proto.String(gpkg.ProtoName)
+	g.pfile.Options = fo
 	g.allProto[pfilename] = g.pfile
 	g.messageIndex = 0
 	g.serviceIndex = 0
@@ -942,6 +1331,9 @@ func (g *Generator) translatePkg(pkgPath string) error {
 			return fmt.Errorf("%s: %v", g.Fset.Position(g.curPos), err)
 		}
 	}
+	if err := g.nestDecls(); err != nil {
+		return fmt.Errorf("%s: %v", g.Fset.Position(g.curPos), err)
+	}
 	g.ignoredGen[*fo.GoPackage] = g.curIgnore
 
 	var leftToTranslate []string
@@ -979,11 +1371,152 @@ func (g *Generator) translatePkg(pkgPath string) error {
 	return nil
 }
 
+// nestDecls moves the messages and enums of the current package which were
+// declared as nested in another message into their parent message, renaming
+// them and updating their source code info to match.
+func (g *Generator) nestDecls() error {
+	nested := g.nestedIn[g.curPkg.PkgPath]
+	if len(nested) == 0 {
+		return nil
+	}
+	msgs := make(map[string]*descriptorpb.DescriptorProto, len(g.pfile.MessageType))
+	for _, msg := range g.pfile.MessageType {
+		msgs[msg.GetName()] = msg
+	}
+	// Check the nested types in the order they are declared, so that the
+	// errors don't depend on the iteration order of the map.
+	names := make([]string, 0, len(nested))
+	for name := range nested {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return nested[names[i]].pos < nested[names[j]].pos })
+	for _, name := range names {
+		decl := nested[name]
+		g.curPos = decl.pos
+		if _, ok := msgs[decl.parent]; !ok {
+			return fmt.Errorf("%s is nested in %q, which is not a message in this package", name, decl.parent)
+		}
+		for p, seen := decl.parent, map[string]bool{name: true}; p != ""; p = nested[p].parent {
+			if seen[p] {
+				return fmt.Errorf("%s is nested in itself", name)
+			}
+			seen[p] = true
+		}
+		// Fields and nested types share the same scope in protobuf, so
+		// a field named like the nested type is named in snake case
+		// instead, as in the usual "Source source = 1;".
+		localName := nestedLocalName(name, decl.parent)
+		for _, field := range msgs[decl.parent].Field {
+			if field.GetName() != localName {
+				continue
+			}
+			snakeName := snaker.CamelToSnake(localName)
+			if snakeName == localName || hasField(msgs[decl.parent], snakeName) {
+				return fmt.Errorf("%s nested in %s conflicts with field %s", name, decl.parent, field.GetName())
+			}
+			if field.JsonName == nil {
+				// Keep the JSON name of the Gunk field.
+				field.JsonName = proto.String(localName)
+			}
+			field.Name = proto.String(snakeName)
+		}
+		if entry := g.curIgnore.messages[name]; entry != nil && entry.ignoring() {
+			return fmt.Errorf("nested message %s cannot be ignored", name)
+		}
+		if entry := g.curIgnore.enums[name]; entry != nil && entry.ignoring() {
+			return fmt.Errorf("nested enum %s cannot be ignored", name)
+		}
+	}
+
+	// Work out the new path of each message and enum, relative to its
+	// parent first, and then as a full path.
+	type position struct {
+		parent string
+		rel    []int32
+	}
+	childCount := make(map[string]int32)
+	msgPos := make([]position, len(g.pfile.MessageType))
+	var topMsgs []*descriptorpb.DescriptorProto
+	for i, msg := range g.pfile.MessageType {
+		decl, ok := nested[msg.GetName()]
+		if !ok {
+			msgPos[i] = position{rel: []int32{messagePath, int32(len(topMsgs))}}
+			topMsgs = append(topMsgs, msg)
+			continue
+		}
+		// Map entries are already nested in the parent.
+		idx := int32(len(msgs[decl.parent].NestedType)) + childCount[decl.parent]
+		childCount[decl.parent]++
+		msgPos[i] = position{parent: decl.parent, rel: []int32{messageNestedPath, idx}}
+	}
+	enumCount := make(map[string]int32)
+	enumPos := make([]position, len(g.pfile.EnumType))
+	var topEnums []*descriptorpb.EnumDescriptorProto
+	for i, enum := range g.pfile.EnumType {
+		decl, ok := nested[enum.GetName()]
+		if !ok {
+			enumPos[i] = position{rel: []int32{enumPath, int32(len(topEnums))}}
+			topEnums = append(topEnums, enum)
+			continue
+		}
+		idx := enumCount[decl.parent]
+		enumCount[decl.parent]++
+		enumPos[i] = position{parent: decl.parent, rel: []int32{messageEnumPath, idx}}
+	}
+	msgIndex := make(map[string]int, len(g.pfile.MessageType))
+	for i, msg := range g.pfile.MessageType {
+		msgIndex[msg.GetName()] = i
+	}
+	var fullPath func(pos position) []int32
+	fullPath = func(pos position) []int32 {
+		if pos.parent == "" {
+			return pos.rel
+		}
+		parent := fullPath(msgPos[msgIndex[pos.parent]])
+		return append(append([]int32{}, parent...), pos.rel...)
+	}
+	for _, loc := range g.pfile.SourceCodeInfo.Location {
+		if len(loc.Path) < 2 {
+			continue
+		}
+		var path []int32
+		switch loc.Path[0] {
+		case messagePath:
+			path = fullPath(msgPos[loc.Path[1]])
+		case enumPath:
+			path = fullPath(enumPos[loc.Path[1]])
+		default:
+			continue
+		}
+		loc.Path = append(append([]int32{}, path...), loc.Path[2:]...)
+	}
+
+	// Move the declarations, in their original order.
+	for _, msg := range g.pfile.MessageType {
+		if decl, ok := nested[msg.GetName()]; ok {
+			parent := msgs[decl.parent]
+			parent.NestedType = append(parent.NestedType, msg)
+			msg.Name = proto.String(nestedLocalName(msg.GetName(), decl.parent))
+		}
+	}
+	for _, enum := range g.pfile.EnumType {
+		if decl, ok := nested[enum.GetName()]; ok {
+			parent := msgs[decl.parent]
+			parent.EnumType = append(parent.EnumType, enum)
+			enum.Name = proto.String(nestedLocalName(enum.GetName(), decl.parent))
+		}
+	}
+	g.pfile.MessageType = topMsgs
+	g.pfile.EnumType = topEnums
+	return nil
+}
+
 // fileOptions will return the proto file options that have been set in the
 // gunk package. These include "JavaPackage", "Deprecated", "PhpNamespace", etc.
-func fileOptions(pkg *loader.GunkPackage) (*descriptorpb.FileOptions, error) {
+func (g *Generator) fileOptions(pkg *loader.GunkPackage) (*descriptorpb.FileOptions, error) {
 	fo := &descriptorpb.FileOptions{}
 	for _, f := range pkg.GunkSyntax {
+		g.curPos = f.Package
 		for _, tag := range pkg.GunkTags[f] {
 			switch s := tag.Type.String(); s {
 			case "github.com/gunk/opt/proto.Package":
@@ -1034,7 +1567,9 @@ func fileOptions(pkg *loader.GunkPackage) (*descriptorpb.FileOptions, error) {
 				o.SkipPrefix = constant.BoolVal(tag.Value)
 				proto.SetExtension(fo, xo.E_FileOverrides, o)
 			default:
-				return nil, fmt.Errorf("gunk package option %q not supported", s)
+				if err := g.customOption(fo, "package", tag); err != nil {
+					return nil, err
+				}
 			}
 		}
 	}
@@ -1056,12 +1591,18 @@ func (g *Generator) appendFile(fpath string, file *ast.File) error {
 		g.pfile.SourceCodeInfo = &descriptorpb.SourceCodeInfo{}
 	}
 
-	g.addDoc(file.Doc.Text(), packagePath)
+	// The package clause only has a location if it is documented, as
+	// there can be many of them in a package.
+	if file.Doc.Text() != "" {
+		g.addLocation(file, token.NoPos, file.Doc.Text(), packagePath)
+	}
+	g.prevEnd = file.Name.End()
 	for _, decl := range file.Decls {
 		g.curPos = decl.Pos()
 		if err := g.translateDecl(decl); err != nil {
 			return err
 		}
+		g.prevEnd = decl.End()
 	}
 	return nil
 }
@@ -1084,6 +1625,9 @@ func (g *Generator) translateDecl(decl ast.Decl) error {
 	default:
 		return fmt.Errorf("invalid declaration token %v", gd.Tok)
 	}
+	if gd.Lparen.IsValid() {
+		g.prevEnd = gd.Lparen + 1
+	}
 	for _, spec := range gd.Specs {
 		ts := spec.(*ast.TypeSpec)
 		g.curPos = ts.Pos()
@@ -1112,32 +1656,91 @@ func (g *Generator) translateDecl(decl ast.Decl) error {
 		default:
 			return fmt.Errorf("invalid declaration type %T", ts.Type)
 		}
+		g.prevEnd = ts.End()
 	}
 	return nil
 }
 
-// addDoc inserts the provided documentation text into protobuf with its path
-// after formatting it into the format proto requires.
-func (g *Generator) addDoc(text string, path ...int32) {
-	if text == "" {
-		return
+// addLocation records the source location of a declaration in the current
+// Gunk file, with its leading, trailing and detached comments. doc is the
+// leading comment text, which may differ from the declaration's doc comment.
+// The detached comments are the ones between prev, the end of the previous
+// declaration, and the declaration.
+func (g *Generator) addLocation(node ast.Node, prev token.Pos, doc string, path ...int32) {
+	var docGroup, comment *ast.CommentGroup
+	start, end := node.Pos(), node.End()
+	switch node := node.(type) {
+	case *ast.File:
+		// Only the package clause.
+		docGroup = node.Doc
+		start, end = node.Package, node.Name.End()
+	case *ast.TypeSpec:
+		docGroup, comment = node.Doc, node.Comment
+	case *ast.Field:
+		docGroup, comment = node.Doc, node.Comment
+	case *ast.ValueSpec:
+		docGroup, comment = node.Doc, node.Comment
+	}
+	loc := &descriptorpb.SourceCodeInfo_Location{
+		Path: path,
+		Span: g.span(start, end),
+	}
+	if doc != "" {
+		loc.LeadingComments = proto.String(protoComment(doc))
+	}
+	if text := comment.Text(); text != "" {
+		loc.TrailingComments = proto.String(protoComment(text))
+	}
+	declStart := start
+	if docGroup != nil {
+		declStart = docGroup.Pos()
+	}
+	prevLine := 0
+	if prev.IsValid() {
+		prevLine = g.Fset.Position(prev).Line
+	}
+	for _, cg := range g.gfile.Comments {
+		if cg == docGroup || cg.Pos() < prev || cg.End() > declStart {
+			continue
+		}
+		if g.Fset.Position(cg.Pos()).Line == prevLine {
+			// A trailing comment of the previous declaration.
+			continue
+		}
+		if text := cg.Text(); text != "" {
+			loc.LeadingDetachedComments = append(loc.LeadingDetachedComments, protoComment(text))
+		}
+	}
+	g.pfile.SourceCodeInfo.Location = append(g.pfile.SourceCodeInfo.Location, loc)
+}
+
+// span returns the span of a source range as used in SourceCodeInfo, with
+// zero-based lines and columns. The end line is omitted if it is the same as
+// the start line.
+//
+// The Gunk files of a package are merged into a single all.proto, and a span
+// can't tell which of them it is in, so there are no spans in packages with
+// several Gunk files, only the comments of the locations.
+func (g *Generator) span(start, end token.Pos) []int32 {
+	if len(g.curPkg.GunkFiles) > 1 {
+		return nil
 	}
-	// go's ast.TypeSpec.Doc.Text() trims left-trailing spaces on each line of multi-line comment,
-	// while proto's LeadingComments needs them
+	from, to := g.Fset.Position(start), g.Fset.Position(end)
+	if from.Line == to.Line {
+		return []int32{int32(from.Line - 1), int32(from.Column - 1), int32(to.Column - 1)}
+	}
+	return []int32{int32(from.Line - 1), int32(from.Column - 1), int32(to.Line - 1), int32(to.Column - 1)}
+}
+
+// protoComment formats comment text as proto comments in SourceCodeInfo.
+func protoComment(text string) string {
+	// go's ast.CommentGroup.Text() trims left-trailing spaces on each line
+	// of multi-line comment, while proto's comments need them
 	//
 	// block comments still look bad, but that's not a priority now
 	lines := strings.Split(text, "\n")
 	newText := " " + strings.Join(lines, "\n ")
-	newText = strings.TrimRight(newText, " \n")
-
-	g.pfile.SourceCodeInfo.Location = append(g.pfile.SourceCodeInfo.Location,
-		&descriptorpb.SourceCodeInfo_Location{
-			Path:            path,
-			LeadingComments: &newText,
-			// just add some nonsense to satisfy protoc-gen-go
-			Span: []int32{1, 2, 3},
-		},
-	)
+	return strings.TrimRight(newText, " \n")
 }
 
 type optIgnore struct {
@@ -1156,6 +1759,12 @@ func (g *Generator) messageOptions(tspec *ast.TypeSpec, entry *ignoredEntry) (*d
 			var ignore optIgnore
 			reflectutil.UnmarshalAST(&ignore, tag.Expr)
 			entry.ignoreFor = append(entry.ignoreFor, ignore.Generator)
+		case "github.com/gunk/opt/message.NestedIn":
+			// Handled in nestDecls, after the whole package is translated.
+		case "github.com/gunk/opt/message.Reserved":
+			// Handled in convertMessage.
+		case "github.com/gunk/opt/option.Extension":
+			// Declares an annotation type, used by customOption.
 		case "github.com/gunk/opt/message.MessageSetWireFormat":
 			o.MessageSetWireFormat = proto.Bool(constant.BoolVal(tag.Value))
 		case "github.com/gunk/opt/message.NoStandardDescriptorAccessor":
@@ -1178,7 +1787,9 @@ func (g *Generator) messageOptions(tspec *ast.TypeSpec, entry *ignoredEntry) (*d
 			xoOpts.HasMany = append(xoOpts.HasMany, otmEntry)
 			xoOk = true
 		default:
-			return nil, fmt.Errorf("gunk message option %q not supported", s)
+			if err := g.customOption(o, "message", tag); err != nil {
+				return nil, err
+			}
 		}
 	}
 	if xoOk {
@@ -1201,6 +1812,8 @@ func (g *Generator) fieldOptions(field *ast.Field, entry *ignoredEntry) (*descri
 			var ignore optIgnore
 			reflectutil.UnmarshalAST(&ignore, tag.Expr)
 			entry.ignoreFor = append(entry.ignoreFor, ignore.Generator)
+		case "github.com/gunk/opt/oneof.Group":
+			// Handled in convertMessage, as it is not a field option.
 		case "github.com/gunk/opt/field.Packed":
 			o.Packed = proto.Bool(constant.BoolVal(tag.Value))
 		case "github.com/gunk/opt/field.Lazy":
@@ -1250,7 +1863,9 @@ func (g *Generator) fieldOptions(field *ast.Field, entry *ignoredEntry) (*descri
 		case "github.com/gunk/opt/xo.Nullable":
 			xoOpts.Nullable, xoOk = constant.BoolVal(tag.Value), true
 		default:
-			return nil, fmt.Errorf("gunk field option %q not supported", s)
+			if err := g.customOption(o, "field", tag); err != nil {
+				return nil, err
+			}
 		}
 	}
 	if xoOk {
@@ -1261,10 +1876,22 @@ func (g *Generator) fieldOptions(field *ast.Field, entry *ignoredEntry) (*descri
 	return o, nil
 }
 
+// fieldOneof returns the name of the oneof the field is a member of, as set
+// using the oneof.Group Gunk tag. It returns an empty string if the field is
+// not part of a oneof.
+func (g *Generator) fieldOneof(field *ast.Field) string {
+	for _, tag := range g.curPkg.GunkTags[field] {
+		if tag.Type.String() == "github.com/gunk/opt/oneof.Group" {
+			return constant.StringVal(tag.Value)
+		}
+	}
+	return ""
+}
+
 // convertMessage converts the provided type spec of a struct into a descriptor
 // that describes a message.
 func (g *Generator) convertMessage(tspec *ast.TypeSpec) (*descriptorpb.DescriptorProto, error) {
-	g.addDoc(tspec.Doc.Text(), messagePath, g.messageIndex)
+	g.addLocation(tspec, g.prevEnd, tspec.Doc.Text(), messagePath, g.messageIndex)
 	msg := &descriptorpb.DescriptorProto{
 		Name: proto.String(tspec.Name.Name),
 	}
@@ -1276,6 +1903,27 @@ func (g *Generator) convertMessage(tspec *ast.TypeSpec) (*descriptorpb.Descripto
 		return nil, fmt.Errorf("error getting message options: %v", err)
 	}
 	msg.Options = messageOptions
+	reserved, err := g.curPkg.TypeReserved(tspec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reserved annotation: %v", err)
+	}
+	if reserved != nil {
+		for _, rng := range reserved.Ranges {
+			// The end of message reserved ranges is exclusive.
+			msg.ReservedRange = append(msg.ReservedRange, &descriptorpb.DescriptorProto_ReservedRange{
+				Start: proto.Int32(int32(rng.From)),
+				End:   proto.Int32(int32(rng.To) + 1),
+			})
+		}
+		msg.ReservedName = reserved.Names
+	}
+	// Maps oneof names to their index in msg.OneofDecl.
+	oneofs := make(map[string]int32)
+	// The oneof name of the previous field, used to ensure that all the
+	// fields of a oneof are declared consecutively.
+	prevOneof := ""
+	// Proto3 optional fields, which need a synthetic oneof each.
+	var optionalFields []*descriptorpb.FieldDescriptorProto
 	// convert fields
 	stype := tspec.Type.(*ast.StructType)
 	for i, field := range stype.Fields.List {
@@ -1283,13 +1931,24 @@ func (g *Generator) convertMessage(tspec *ast.TypeSpec) (*descriptorpb.Descripto
 			return nil, fmt.Errorf("fields must have exactly one name")
 		}
 		fieldName := field.Names[0].Name
-		g.addDoc(field.Doc.Text(), messagePath, g.messageIndex, messageFieldPath, int32(i))
+		prev := stype.Fields.Opening + 1
+		if i > 0 {
+			prev = stype.Fields.List[i-1].End()
+		}
+		g.addLocation(field, prev, field.Doc.Text(), messagePath, g.messageIndex, messageFieldPath, int32(i))
 		ftype := g.curPkg.TypesInfo.TypeOf(field.Type)
 		g.curPos = field.Pos()
 		var ptype descriptorpb.FieldDescriptorProto_Type
 		var plabel descriptorpb.FieldDescriptorProto_Label
 		var tname string
 		var msgNestedType *descriptorpb.DescriptorProto
+		// Pointers to scalars are translated to proto3 optional fields,
+		// which track whether the field has been set.
+		optional := false
+		if ptr, ok := ftype.(*types.Pointer); ok {
+			ftype = ptr.Elem()
+			optional = true
+		}
 		// Check to see if the type is a map. Maps need to be made into a
 		// repeated nested message containing key and value fields.
 		if mtype, ok := ftype.(*types.Map); ok {
@@ -1311,6 +1970,9 @@ func (g *Generator) convertMessage(tspec *ast.TypeSpec) (*descriptorpb.Descripto
 		if ptype == 0 {
 			return nil, fmt.Errorf("unsupported field type: %v", ftype)
 		}
+		if optional && (plabel == descriptorpb.FieldDescriptorProto_LABEL_REPEATED || ptype == descriptorpb.FieldDescriptorProto_TYPE_MESSAGE) {
+			return nil, fmt.Errorf("optional field %s must be a pointer to a scalar or an enum", fieldName)
+		}
 		// Check that the struct field has a tag. We currently
 		// require all struct fields to have a tag; this is used
 		// to assign the position number for a field, ie: `pb:"1"`
@@ -1333,22 +1995,84 @@ func (g *Generator) convertMessage(tspec *ast.TypeSpec) (*descriptorpb.Descripto
 		if err != nil {
 			return nil, fmt.Errorf("error getting field options: %v", err)
 		}
-		msg.Field = append(msg.Field, &descriptorpb.FieldDescriptorProto{
-			Name:     proto.String(fieldName),
-			Number:   num,
-			TypeName: protoStringOrNil(tname),
-			Type:     &ptype,
-			Label:    &plabel,
-			JsonName: jsonName(tag),
-			Options:  fieldOptions,
-		})
+		var oneofIndex *int32
+		if oneof := g.fieldOneof(field); oneof != "" {
+			if plabel == descriptorpb.FieldDescriptorProto_LABEL_REPEATED {
+				return nil, fmt.Errorf("oneof field %s cannot be repeated or a map", fieldName)
+			}
+			if optional {
+				return nil, fmt.Errorf("oneof field %s cannot be optional", fieldName)
+			}
+			idx, ok := oneofs[oneof]
+			if !ok {
+				idx = int32(len(msg.OneofDecl))
+				oneofs[oneof] = idx
+				msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{
+					Name: proto.String(oneof),
+				})
+			} else if prevOneof != oneof {
+				return nil, fmt.Errorf("fields of oneof %q must be declared consecutively", oneof)
+			}
+			oneofIndex = proto.Int32(idx)
+			prevOneof = oneof
+		} else {
+			prevOneof = ""
+		}
+		pfield := &descriptorpb.FieldDescriptorProto{
+			Name:       proto.String(fieldName),
+			Number:     num,
+			TypeName:   protoStringOrNil(tname),
+			Type:       &ptype,
+			Label:      &plabel,
+			JsonName:   jsonName(tag),
+			Options:    fieldOptions,
+			OneofIndex: oneofIndex,
+		}
+		if optional {
+			pfield.Proto3Optional = proto.Bool(true)
+			optionalFields = append(optionalFields, pfield)
+		}
+		msg.Field = append(msg.Field, pfield)
 		msgEntry.items[fieldName] = entry
 	}
+	// Each proto3 optional field is the only member of a synthetic oneof.
+	// Synthetic oneofs must be declared after all other oneofs.
+	for _, pfield := range optionalFields {
+		pfield.OneofIndex = proto.Int32(int32(len(msg.OneofDecl)))
+		msg.OneofDecl = append(msg.OneofDecl, &descriptorpb.OneofDescriptorProto{
+			Name: proto.String(syntheticOneofName(msg, pfield.GetName())),
+		})
+	}
 	g.curIgnore.messages[tspec.Name.Name] = msgEntry
 	g.messageIndex++
 	return msg, nil
 }
 
+// syntheticOneofName returns the name of the synthetic oneof for the proto3
+// optional field with the provided name. Like protoc, it uses the field name
+// prefixed with an underscore, prepending "X" until the name doesn't conflict
+// with any other field or oneof of the message.
+func syntheticOneofName(msg *descriptorpb.DescriptorProto, fieldName string) string {
+	name := "_" + fieldName
+	taken := func(name string) bool {
+		for _, field := range msg.Field {
+			if field.GetName() == name {
+				return true
+			}
+		}
+		for _, oneof := range msg.OneofDecl {
+			if oneof.GetName() == name {
+				return true
+			}
+		}
+		return false
+	}
+	for taken(name) {
+		name = "X" + name
+	}
+	return name
+}
+
 // serviceOptions returns the ServiceOptions set using Gunk tags.
 func (g *Generator) serviceOptions(tspec *ast.TypeSpec, entry *ignoredEntry) (*descriptorpb.ServiceOptions, error) {
 	o := &descriptorpb.ServiceOptions{}
@@ -1361,7 +2085,9 @@ func (g *Generator) serviceOptions(tspec *ast.TypeSpec, entry *ignoredEntry) (*d
 		case "github.com/gunk/opt/service.Deprecated":
 			o.Deprecated = proto.Bool(constant.BoolVal(tag.Value))
 		default:
-			return nil, fmt.Errorf("gunk service option %q not supported", s)
+			if err := g.customOption(o, "service", tag); err != nil {
+				return nil, err
+			}
 		}
 	}
 	reflectutil.SetDefaults(o)
@@ -1436,7 +2162,9 @@ func (g *Generator) methodOptions(method *ast.Field, entry *ignoredEntry) (*desc
 			proto.SetExtension(o, options.E_Openapiv2Operation, op)
 			g.addProtoDep("protoc-gen-openapiv2/options/annotations.proto")
 		default:
-			return nil, fmt.Errorf("gunk method option %q not supported", s)
+			if err := g.customOption(o, "method", tag); err != nil {
+				return nil, err
+			}
 		}
 	}
 	if httpRule != nil {
@@ -1448,6 +2176,7 @@ func (g *Generator) methodOptions(method *ast.Field, entry *ignoredEntry) (*desc
 }
 
 func (g *Generator) convertService(tspec *ast.TypeSpec) (*descriptorpb.ServiceDescriptorProto, error) {
+	g.addLocation(tspec, g.prevEnd, tspec.Doc.Text(), servicePath, g.serviceIndex)
 	srv := &descriptorpb.ServiceDescriptorProto{
 		Name: proto.String(tspec.Name.Name),
 	}
@@ -1464,7 +2193,11 @@ func (g *Generator) convertService(tspec *ast.TypeSpec) (*descriptorpb.ServiceDe
 		if len(method.Names) != 1 {
 			return nil, fmt.Errorf("methods must have exactly one name")
 		}
-		g.addDoc(method.Doc.Text(), servicePath, g.serviceIndex, serviceMethodPath, int32(i))
+		prev := itype.Methods.Opening + 1
+		if i > 0 {
+			prev = itype.Methods.List[i-1].End()
+		}
+		g.addLocation(method, prev, method.Doc.Text(), servicePath, g.serviceIndex, serviceMethodPath, int32(i))
 		g.curPos = method.Pos()
 		methodName := method.Names[0].Name
 		pmethod := &descriptorpb.MethodDescriptorProto{
@@ -1503,10 +2236,11 @@ func (g *Generator) convertService(tspec *ast.TypeSpec) (*descriptorpb.ServiceDe
 // https://developers.google.com/protocol-buffers/docs/proto#maps
 func (g *Generator) convertMap(parentName, fieldName string, mapTyp *types.Map) (string, *descriptorpb.DescriptorProto, error) {
 	mapName := fieldName + "Entry"
-	typeName, err := g.qualifiedTypeName(parentName+"."+mapName, nil)
+	parentTypeName, err := g.qualifiedTypeName(parentName, nil)
 	if err != nil {
 		return "", nil, err
 	}
+	typeName := parentTypeName + "." + mapName
 	keyType, _, keyTypeName, err := g.convertType(mapTyp.Key())
 	if err != nil {
 		return "", nil, err
@@ -1586,12 +2320,20 @@ func (g *Generator) enumOptions(tspec *ast.TypeSpec, entry *ignoredEntry) (*desc
 			var ignore optIgnore
 			reflectutil.UnmarshalAST(&ignore, tag.Expr)
 			entry.ignoreFor = append(entry.ignoreFor, ignore.Generator)
+		case "github.com/gunk/opt/enum.NestedIn":
+			// Handled in nestDecls, after the whole package is translated.
+		case "github.com/gunk/opt/enum.Reserved":
+			// Handled in convertEnum.
+		case "github.com/gunk/opt/option.Extension":
+			// Declares an annotation type, used by customOption.
 		case "github.com/gunk/opt/enum.AllowAlias":
 			o.AllowAlias = proto.Bool(constant.BoolVal(tag.Value))
 		case "github.com/gunk/opt/enum.Deprecated":
 			o.Deprecated = proto.Bool(constant.BoolVal(tag.Value))
 		default:
-			return nil, fmt.Errorf("gunk enum option %q not supported", s)
+			if err := g.customOption(o, "enum", tag); err != nil {
+				return nil, err
+			}
 		}
 	}
 	reflectutil.SetDefaults(o)
@@ -1610,7 +2352,9 @@ func (g *Generator) enumValueOptions(vspec *ast.ValueSpec, entry *ignoredEntry)
 		case "github.com/gunk/opt/enumvalues.Deprecated":
 			o.Deprecated = proto.Bool(constant.BoolVal(tag.Value))
 		default:
-			return nil, fmt.Errorf("gunk enumvalue option %q not supported", s)
+			if err := g.customOption(o, "enumvalue", tag); err != nil {
+				return nil, err
+			}
 		}
 	}
 	reflectutil.SetDefaults(o)
@@ -1620,7 +2364,8 @@ func (g *Generator) enumValueOptions(vspec *ast.ValueSpec, entry *ignoredEntry)
 // convertEnum converts the provided const TypeSpec to an EnumDescriptorProto.
 // It returns (nil, nil) if there are no values for the enum type.
 func (g *Generator) convertEnum(tspec *ast.TypeSpec) (*descriptorpb.EnumDescriptorProto, error) {
-	g.addDoc(tspec.Doc.Text(), enumPath, g.enumIndex)
+	numLocs := len(g.pfile.SourceCodeInfo.Location)
+	g.addLocation(tspec, g.prevEnd, tspec.Doc.Text(), enumPath, g.enumIndex)
 	enum := &descriptorpb.EnumDescriptorProto{
 		Name: proto.String(tspec.Name.Name),
 	}
@@ -1632,13 +2377,38 @@ func (g *Generator) convertEnum(tspec *ast.TypeSpec) (*descriptorpb.EnumDescript
 		return nil, fmt.Errorf("error getting enum options: %v", err)
 	}
 	enum.Options = enumOptions
+	reserved, err := g.curPkg.TypeReserved(tspec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reserved annotation: %v", err)
+	}
+	if reserved != nil {
+		for _, rng := range reserved.Ranges {
+			// Unlike for messages, the end of enum reserved ranges
+			// is inclusive.
+			enum.ReservedRange = append(enum.ReservedRange, &descriptorpb.EnumDescriptorProto_EnumReservedRange{
+				Start: proto.Int32(int32(rng.From)),
+				End:   proto.Int32(int32(rng.To)),
+			})
+		}
+		enum.ReservedName = reserved.Names
+	}
 	enumType := g.curPkg.TypesInfo.TypeOf(tspec.Name)
-	for _, decl := range g.gfile.Decls {
+	for d, decl := range g.gfile.Decls {
 		gd, ok := decl.(*ast.GenDecl)
 		if !ok || gd.Tok != token.CONST {
 			continue
 		}
+		prev := g.gfile.Name.End()
+		if d > 0 {
+			prev = g.gfile.Decls[d-1].End()
+		}
+		if gd.Lparen.IsValid() {
+			prev = gd.Lparen + 1
+		}
 		for i, spec := range gd.Specs {
+			if i > 0 {
+				prev = gd.Specs[i-1].End()
+			}
 			vs := spec.(*ast.ValueSpec)
 			// .proto files have the same limitation, and it
 			// allows per-value godocs
@@ -1652,20 +2422,20 @@ func (g *Generator) convertEnum(tspec *ast.TypeSpec) (*descriptorpb.EnumDescript
 			g.curPos = vs.Pos()
 			docText := vs.Doc.Text()
 
-			switch {
-			case docText == "":
-				// The original comment only had gunk tags, and
-				// no actual documentation for us to keep.
-			case strings.HasPrefix(docText, name.Name):
+			if strings.HasPrefix(docText, name.Name) {
 				// SomeVal will be exported as SomeType_SomeVal
-				docText = tspec.Name.Name + "_" + vs.Doc.Text()
-				fallthrough
-			default:
-				g.addDoc(docText, enumPath, g.enumIndex,
-					enumValuePath, int32(i))
+				docText = tspec.Name.Name + "_" + docText
 			}
+			g.addLocation(vs, prev, docText, enumPath, g.enumIndex,
+				enumValuePath, int32(len(enum.Value)))
 			val := g.curPkg.TypesInfo.Defs[name].(*types.Const).Val()
 			ival, _ := constant.Int64Val(val)
+			if reserved.HasNumber(int(ival)) {
+				return nil, fmt.Errorf("enum value %s uses reserved number %d", name.Name, ival)
+			}
+			if reserved.HasName(name.Name) {
+				return nil, fmt.Errorf("enum value name %s is reserved", name.Name)
+			}
 			valEntry := new(ignoredEntry)
 			enumValueOptions, err := g.enumValueOptions(vs, valEntry)
 			if err != nil {
@@ -1681,11 +2451,13 @@ func (g *Generator) convertEnum(tspec *ast.TypeSpec) (*descriptorpb.EnumDescript
 		}
 	}
 	g.curIgnore.enums[tspec.Name.Name] = enumEntry
-	g.enumIndex++
-	// If an enum doesn't have any values
+	// If an enum doesn't have any values, it isn't added to the file, so
+	// neither should its locations be.
 	if len(enum.Value) == 0 {
+		g.pfile.SourceCodeInfo.Location = g.pfile.SourceCodeInfo.Location[:numLocs]
 		return nil, nil
 	}
+	g.enumIndex++
 	return enum, nil
 }
 
@@ -1693,17 +2465,73 @@ func (g *Generator) convertEnum(tspec *ast.TypeSpec) (*descriptorpb.EnumDescript
 // package is nil, it will format the type for the current package that is
 // being processed.
 //
-// Currently we format the type as ".<pkg_name>.<type_name>"
+// Currently we format the type as ".<pkg_name>.<type_name>", where nested
+// types are formatted as ".<pkg_name>.<parent_name>.<type_name>".
 func (g *Generator) qualifiedTypeName(typeName string, pkg *types.Package) (string, error) {
 	// If pkg is nil, we should format the type for the current package.
 	if pkg == nil {
-		return "." + g.curPkg.ProtoName + "." + typeName, nil
+		return "." + g.curPkg.ProtoName + "." + g.nestedTypeName(g.curPkg.PkgPath, typeName, nil), nil
 	}
 	gpkg, ok := g.gunkPkgs[pkg.Path()]
 	if !ok {
 		return "", fmt.Errorf("failed to get package %s to get qualified type name", pkg.Path())
 	}
-	return "." + gpkg.ProtoName + "." + typeName, nil
+	return "." + gpkg.ProtoName + "." + g.nestedTypeName(pkg.Path(), typeName, nil), nil
+}
+
+// nestedTypeName returns the name of a type relative to its proto package,
+// following the chain of messages it is nested in. Cycles are reported when
+// nesting the declarations, so here they simply end the chain.
+func (g *Generator) nestedTypeName(pkgPath, typeName string, seen map[string]bool) string {
+	decl, ok := g.nestedIn[pkgPath][typeName]
+	if !ok || seen[typeName] {
+		return typeName
+	}
+	if seen == nil {
+		seen = make(map[string]bool)
+	}
+	seen[typeName] = true
+	return g.nestedTypeName(pkgPath, decl.parent, seen) + "." + nestedLocalName(typeName, decl.parent)
+}
+
+// hasField returns whether the message has a field with the name.
+func hasField(msg *descriptorpb.DescriptorProto, name string) bool {
+	for _, field := range msg.Field {
+		if field.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// nestedLocalName returns the proto name of a nested type inside its parent.
+// The parent name prefix is trimmed, so that a Gunk type "Event_Source" nested
+// in "Event" becomes "Event.Source", as protoc-gen-go and friends would name
+// it "Event_Source" again.
+func nestedLocalName(typeName, parent string) string {
+	if name := strings.TrimPrefix(typeName, parent+"_"); name != "" {
+		return name
+	}
+	return typeName
+}
+
+// wellKnownTypes maps the Gunk types of the protobuf well-known types, other
+// than the ones with a Go equivalent such as time.Time, to their proto file and
+// full name.
+var wellKnownTypes = map[string]struct{ file, name string }{
+	"github.com/gunk/opt/types.Any":         {"google/protobuf/any.proto", ".google.protobuf.Any"},
+	"github.com/gunk/opt/types.FieldMask":   {"google/protobuf/field_mask.proto", ".google.protobuf.FieldMask"},
+	"github.com/gunk/opt/types.Struct":      {"google/protobuf/struct.proto", ".google.protobuf.Struct"},
+	"github.com/gunk/opt/types.ListValue":   {"google/protobuf/struct.proto", ".google.protobuf.ListValue"},
+	"github.com/gunk/opt/types.DoubleValue": {"google/protobuf/wrappers.proto", ".google.protobuf.DoubleValue"},
+	"github.com/gunk/opt/types.FloatValue":  {"google/protobuf/wrappers.proto", ".google.protobuf.FloatValue"},
+	"github.com/gunk/opt/types.Int64Value":  {"google/protobuf/wrappers.proto", ".google.protobuf.Int64Value"},
+	"github.com/gunk/opt/types.UInt64Value": {"google/protobuf/wrappers.proto", ".google.protobuf.UInt64Value"},
+	"github.com/gunk/opt/types.Int32Value":  {"google/protobuf/wrappers.proto", ".google.protobuf.Int32Value"},
+	"github.com/gunk/opt/types.UInt32Value": {"google/protobuf/wrappers.proto", ".google.protobuf.UInt32Value"},
+	"github.com/gunk/opt/types.BoolValue":   {"google/protobuf/wrappers.proto", ".google.protobuf.BoolValue"},
+	"github.com/gunk/opt/types.StringValue": {"google/protobuf/wrappers.proto", ".google.protobuf.StringValue"},
+	"github.com/gunk/opt/types.BytesValue":  {"google/protobuf/wrappers.proto", ".google.protobuf.BytesValue"},
 }
 
 // convertType converts a Go field or parameter type to Protobuf, returning its
@@ -1745,6 +2573,24 @@ func (g *Generator) convertType(typ types.Type) (descriptorpb.FieldDescriptorPro
 		case "encoding/json.RawMessage":
 			g.addProtoDep("google/protobuf/struct.proto")
 			return descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, ".google.protobuf.Value", nil
+		// Marker types for the scalar wire encodings which have no
+		// equivalent Go type.
+		case "github.com/gunk/opt/types.Sint32":
+			return descriptorpb.FieldDescriptorProto_TYPE_SINT32, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, "", nil
+		case "github.com/gunk/opt/types.Sint64":
+			return descriptorpb.FieldDescriptorProto_TYPE_SINT64, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, "", nil
+		case "github.com/gunk/opt/types.Fixed32":
+			return descriptorpb.FieldDescriptorProto_TYPE_FIXED32, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, "", nil
+		case "github.com/gunk/opt/types.Fixed64":
+			return descriptorpb.FieldDescriptorProto_TYPE_FIXED64, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, "", nil
+		case "github.com/gunk/opt/types.Sfixed32":
+			return descriptorpb.FieldDescriptorProto_TYPE_SFIXED32, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, "", nil
+		case "github.com/gunk/opt/types.Sfixed64":
+			return descriptorpb.FieldDescriptorProto_TYPE_SFIXED64, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, "", nil
+		}
+		if wkt, ok := wellKnownTypes[typ.String()]; ok {
+			g.addProtoDep(wkt.file)
+			return descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL, wkt.name, nil
 		}
 		fullName, err := g.qualifiedTypeName(typ.Obj().Name(), typ.Obj().Pkg())
 		if err != nil {
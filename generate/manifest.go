@@ -0,0 +1,192 @@
+package generate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gunk/gunk/loader"
+)
+
+// manifestName is the name of the file in a package directory which lists the
+// files generated for the package.
+const manifestName = ".gunkmanifest"
+
+const manifestHeader = `# Code generated by gunk. DO NOT EDIT.
+#
+# Files generated for this package, and the generator of each. Files no longer
+# generated are removed by gunk generate, and gunk clean removes all of them.
+`
+
+// manifestEntry is a file written by a generator.
+type manifestEntry struct {
+	gen  string // generator, such as "go" or "grpc-gateway"
+	path string
+}
+
+// readManifest reads the manifest in the package directory dir, returning
+// entries with absolute paths. It returns no entries if there is no manifest.
+func readManifest(dir string) ([]manifestEntry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []manifestEntry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		gen, path, ok := strings.Cut(text, "\t")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: invalid manifest entry %q", manifestName, line, text)
+		}
+		entries = append(entries, manifestEntry{
+			gen:  gen,
+			path: filepath.Join(dir, filepath.FromSlash(path)),
+		})
+	}
+	return entries, sc.Err()
+}
+
+// writeManifest writes the manifest in the package directory dir, with paths
+// relative to it. If there are no entries, the manifest is removed instead.
+func writeManifest(dir string, entries []manifestEntry) error {
+	path := filepath.Join(dir, manifestName)
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	buf.WriteString(manifestHeader)
+	for _, e := range entries {
+		rel, err := filepath.Rel(dir, e.path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "%s\t%s\n", e.gen, filepath.ToSlash(rel))
+	}
+	return writeFile(path, buf.Bytes())
+}
+
+// updateManifest records the files generated for the package in its manifest,
+// and removes the files in the previous manifest that are no longer generated.
+// With g.check, these are reported as stale instead. Nothing is done when the
+// files are written to an Output, as the source tree is left untouched.
+func (g *Generator) updateManifest(pkg *loader.GunkPackage) error {
+	if g.collected != nil {
+		return nil
+	}
+	dir, err := filepath.Abs(pkg.Dir)
+	if err != nil {
+		return err
+	}
+	old, err := readManifest(dir)
+	if err != nil {
+		return err
+	}
+	// Sort and deduplicate the entries, as the generators ran
+	// concurrently.
+	generated := make(map[string]bool)
+	var entries []manifestEntry
+	for _, e := range g.outputs[pkg.PkgPath] {
+		if e.path, err = filepath.Abs(e.path); err != nil {
+			return err
+		}
+		if !generated[e.path] {
+			generated[e.path] = true
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].path < entries[j].path
+	})
+	for _, e := range old {
+		if generated[e.path] {
+			continue
+		}
+		if g.check {
+			data, err := ioutil.ReadFile(e.path)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			g.addStale(staleFile{path: e.path, old: data, removed: true})
+			continue
+		}
+		if err := g.removeGenerated(dir, e.path); err != nil {
+			return err
+		}
+	}
+	if g.check {
+		return nil
+	}
+	return writeManifest(dir, entries)
+}
+
+// removeGenerated removes a generated file of the package in dir, along with
+// the directories within dir left empty by removing it.
+func (g *Generator) removeGenerated(dir, path string) error {
+	g.Logger.Verbosef("removing %s", path)
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for sub := filepath.Dir(path); strings.HasPrefix(sub, dir+string(filepath.Separator)); sub = filepath.Dir(sub) {
+		// Fails if the directory isn't empty.
+		if os.Remove(sub) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Clean removes the files generated for the specified Gunk packages, as
+// listed in their manifests, and the manifests themselves.
+func Clean(dir string, args ...string) error {
+	return New(Options{Dir: dir, Logger: stderrLogger()}).Clean(args...)
+}
+
+// Clean removes the generated files of the specified Gunk packages like the
+// Clean function, with the options of the Generator.
+func (g *Generator) Clean(args ...string) error {
+	pkgs, err := g.Load(args...)
+	if err != nil {
+		return fmt.Errorf("error loading packages: %w", err)
+	}
+	if len(pkgs) == 0 {
+		return fmt.Errorf("no Gunk packages to clean")
+	}
+	for _, pkg := range pkgs {
+		dir, err := filepath.Abs(pkg.Dir)
+		if err != nil {
+			return err
+		}
+		entries, err := readManifest(dir)
+		if err != nil {
+			return fmt.Errorf("unable to read manifest of pkg %s: %w", pkg.PkgPath, err)
+		}
+		for _, e := range entries {
+			if err := g.removeGenerated(dir, e.path); err != nil {
+				return err
+			}
+		}
+		if err := writeManifest(dir, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package generate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/diagnostic"
+	"github.com/gunk/gunk/log"
+)
+
+// Options configures a Generator created with New, for programs which use
+// gunk as a library.
+type Options struct {
+	// Dir is the directory to load the packages from, and the one the
+	// paths of the generated files are relative to. If empty, the current
+	// directory is used.
+	Dir string
+	// Overlay holds the contents of files by absolute path, to be used
+	// instead of the files on disk. See loader.Loader.Overlay.
+	Overlay map[string][]byte
+	// Generators, if not nil, are run for all packages instead of the
+	// generators in their .gunkconfig files, which are then optional. A
+	// relative Out is relative to the generator's ConfigDir, or to Dir if
+	// it's empty.
+	Generators []config.Generator
+	// Logger logs the progress and the commands run. If nil, nothing is
+	// logged.
+	Logger *log.Logger
+	// Diagnostics accumulates the errors loading the packages. If nil,
+	// they are logged with Logger.
+	Diagnostics *diagnostic.Printer
+	// Jobs is the maximum number of code generators to run concurrently.
+	// If zero or negative, GOMAXPROCS is used.
+	Jobs int
+}
+
+// New returns a Generator to generate Gunk packages with the options. Generate
+// returns the generated files instead of writing them to disk, while Run,
+// Check, RunOutput, Watch and Clean work like the functions of the same name.
+//
+// A Generator keeps the state of the packages it generates, so a new one has
+// to be created for every call to one of its methods.
+func New(opts Options) *Generator {
+	g := NewGenerator(opts.Dir)
+	g.opts = opts
+	g.Overlay = opts.Overlay
+	g.Jobs = opts.Jobs
+	g.Logger = opts.Logger
+	g.Diagnostics = opts.Diagnostics
+	if opts.Generators != nil {
+		g.generators = make([]config.Generator, len(opts.Generators))
+		for i, gen := range opts.Generators {
+			if gen.ConfigDir == "" {
+				gen.ConfigDir = opts.Dir
+			}
+			g.generators[i] = gen
+		}
+	}
+	return g
+}
+
+// Generate loads and generates the Gunk packages matching the patterns, and
+// returns the generated files by slash-separated path, relative to the
+// directory of the Generator. Nothing is written to disk. Once ctx is done,
+// the generators still running are killed, and its error is returned.
+func (g *Generator) Generate(ctx context.Context, patterns ...string) (map[string][]byte, error) {
+	g.collected = make(map[string][]byte)
+	if err := g.generate(ctx, patterns...); err != nil {
+		return nil, err
+	}
+	base := g.Dir
+	if base == "" {
+		base = "."
+	}
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte, len(g.collected))
+	for path, data := range g.collected {
+		rel, err := filepath.Rel(base, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("generated file %s is outside of %s", path, base)
+		}
+		files[filepath.ToSlash(rel)] = data
+	}
+	return files, nil
+}
+
+// stderrLogger returns the Logger of the functions which don't take Options,
+// printing to standard error like gunk does by default.
+func stderrLogger() *log.Logger {
+	return log.New(log.NewTextHandler(os.Stderr), log.Options{})
+}
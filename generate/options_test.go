@@ -0,0 +1,86 @@
+package generate
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/gunk/gunk/config"
+)
+
+// writePlugin writes an executable shell script to run as a generator.
+func writePlugin(t *testing.T, dir, script string) string {
+	t.Helper()
+	path := filepath.Join(dir, "protoc-gen-test")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newModule returns a module directory, with the Gunk file only in the
+// overlay.
+func newModule(t *testing.T) (string, map[string][]byte) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("plugins are shell scripts")
+	}
+	t.Setenv("GUNK_CACHE", "off")
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/api\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	overlay := map[string][]byte{
+		filepath.Join(dir, "api.gunk"): []byte("package api\n\ntype Message struct {\n\tName string `pb:\"1\" json:\"name\"`\n}\n"),
+	}
+	return dir, overlay
+}
+
+func TestGenerate(t *testing.T) {
+	dir, overlay := newModule(t)
+	// A CodeGeneratorResponse with a single file named "out.txt" and the
+	// content "generated\n".
+	plugin := writePlugin(t, t.TempDir(), `cat >/dev/null
+printf '\172\025\012\007out.txt\172\012generated\012'
+`)
+	g := New(Options{
+		Dir:        dir,
+		Overlay:    overlay,
+		Generators: []config.Generator{{Command: plugin}},
+	})
+	files, err := g.Generate(context.Background(), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(files["out.txt"]), "generated\n"; got != want || len(files) != 1 {
+		t.Fatalf("got files %q, want out.txt with %q", files, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("out.txt was written to disk: %v", err)
+	}
+}
+
+func TestGenerateCancel(t *testing.T) {
+	dir, overlay := newModule(t)
+	plugin := writePlugin(t, t.TempDir(), "exec sleep 60\n")
+	g := New(Options{
+		Dir:        dir,
+		Overlay:    overlay,
+		Generators: []config.Generator{{Command: plugin}},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := g.Generate(ctx, ".")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 30*time.Second {
+		t.Fatalf("the plugin wasn't killed, Generate took %v", d)
+	}
+}
@@ -0,0 +1,158 @@
+package generate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Output is where to write the generated files to instead of the source tree,
+// for builds that only allow a tool to write to its declared outputs. The
+// files keep their paths relative to the directory gunk is run in.
+type Output struct {
+	// Dir is the directory to write the files to.
+	Dir string
+	// Archive is the path of a .zip, .tar, .tar.gz or .tgz archive to
+	// write the files to. If it is "-", a tar archive is written to Stdout.
+	Archive string
+	Stdout  io.Writer
+}
+
+// archiveTime is the modification time of all archived files, so that
+// archives only change when the generated files do.
+var archiveTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// RunOutput generates the specified Gunk packages like Run, but collects the
+// generated files and writes them all to out once generation succeeds. The
+// source tree is left untouched, including the manifests of the packages.
+func RunOutput(dir string, out Output, args ...string) error {
+	return New(Options{Dir: dir, Logger: stderrLogger()}).RunOutput(context.Background(), out, args...)
+}
+
+// RunOutput generates the specified Gunk packages to out like the RunOutput
+// function, with the options of the Generator.
+func (g *Generator) RunOutput(ctx context.Context, out Output, args ...string) error {
+	if out.Archive != "" && out.Archive != "-" && archiveFormat(out.Archive) == "" {
+		return fmt.Errorf("unsupported archive %s: use .zip, .tar, .tar.gz or .tgz", out.Archive)
+	}
+	files, err := g.Generate(ctx, args...)
+	if err != nil {
+		return err
+	}
+	switch {
+	case out.Dir != "":
+		return writeOutputDir(out.Dir, files)
+	case out.Archive == "-":
+		return writeTar(out.Stdout, files)
+	}
+	return writeArchive(out.Archive, files)
+}
+
+// sortedNames returns the names of the generated files in order, so that
+// archives are always written the same way.
+func sortedNames(files map[string][]byte) []string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// archiveFormat returns the format of the archive at path from its
+// extension, or the empty string if it isn't supported.
+func archiveFormat(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".zip"):
+		return "zip"
+	case strings.HasSuffix(path, ".tar"):
+		return "tar"
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return "tgz"
+	}
+	return ""
+}
+
+func writeOutputDir(dir string, files map[string][]byte) error {
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := mkdirAll(filepath.Dir(path)); err != nil {
+			return err
+		}
+		if err := writeFile(path, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeArchive(path string, files map[string][]byte) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	switch archiveFormat(path) {
+	case "zip":
+		return writeZip(f, files)
+	case "tgz":
+		zw := gzip.NewWriter(f)
+		if err := writeTar(zw, files); err != nil {
+			return err
+		}
+		return zw.Close()
+	}
+	return writeTar(f, files)
+}
+
+func writeTar(w io.Writer, files map[string][]byte) error {
+	tw := tar.NewWriter(w)
+	for _, name := range sortedNames(files) {
+		data := files[name]
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0o644,
+			Size:    int64(len(data)),
+			ModTime: archiveTime,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func writeZip(w io.Writer, files map[string][]byte) error {
+	zw := zip.NewWriter(w)
+	for _, name := range sortedNames(files) {
+		hdr := &zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: archiveTime,
+		}
+		hdr.SetMode(0o644)
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(files[name]); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
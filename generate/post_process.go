@@ -1,11 +1,79 @@
 package generate
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
 	"github.com/gunk/gunk/config"
 	"github.com/gunk/gunk/loader"
+	"github.com/gunk/gunk/log"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/pluginpb"
 	"mvdan.cc/gofumpt/format"
 )
 
+// postProcessFile processes a generated file before writing it to path, first
+// with the built-in post processing for the language, and then with the
+// generator's postproc command, if any.
+func (g *Generator) postProcessFile(ctx context.Context, input []byte, gen config.Generator, mainPkgPath, path string) ([]byte, error) {
+	if mainPkgPath == "" && gen.FixPaths {
+		return nil, fmt.Errorf("cannot fix paths in generate_single mode")
+	}
+	input, err := postProcess(input, gen, mainPkgPath, g.gunkPkgs)
+	if err != nil || gen.Postproc == "" {
+		return input, err
+	}
+	pkgName := "generate_single"
+	if pkg, ok := g.gunkPkgs[mainPkgPath]; ok {
+		pkgName = pkg.Name
+	}
+	return g.runPostproc(ctx, input, gen.Postproc, path, pkgName)
+}
+
+// runPostproc pipes a generated file through a postproc command. The command
+// is split into arguments on spaces, and each one is executed as a template
+// with the path of the file as File and the package name as Package. The
+// output is cached like the output of generators.
+func (g *Generator) runPostproc(ctx context.Context, input []byte, postproc, path, pkgName string) ([]byte, error) {
+	var args []string
+	for _, field := range strings.Fields(postproc) {
+		tpl, err := template.New("postproc").Parse(field)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tpl.Execute(&buf, map[string]string{
+			"File":    path,
+			"Package": pkgName,
+		}); err != nil {
+			return nil, err
+		}
+		args = append(args, buf.String())
+	}
+	key := append([]byte(strings.Join(args, "\x00")+"\x00"), input...)
+	resp, err := g.cache.run(args[0], key, func() (*pluginpb.CodeGeneratorResponse, error) {
+		cmd := g.Logger.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdin = bytes.NewReader(input)
+		out, err := g.Logger.Output(cmd)
+		if err != nil {
+			return nil, log.ExecError(args[0], err)
+		}
+		return &pluginpb.CodeGeneratorResponse{
+			File: []*pluginpb.CodeGeneratorResponse_File{{
+				Name:    proto.String(path),
+				Content: proto.String(string(out)),
+			}},
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(resp.File[0].GetContent()), nil
+}
+
 // postProcess processes the input file before writing to output file.
 func postProcess(input []byte, gen config.Generator, mainPkgPath string, pkgs map[string]*loader.GunkPackage) ([]byte, error) {
 	code := gen.Code()
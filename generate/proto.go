@@ -15,6 +15,8 @@ const (
 	enumPath          = 5 // FileDescriptorProto.EnumType
 	servicePath       = 6 // FileDescriptorProto.Service
 	messageFieldPath  = 2 // DescriptorProto.Field
+	messageNestedPath = 3 // DescriptorProto.NestedType
+	messageEnumPath   = 4 // DescriptorProto.EnumType
 	enumValuePath     = 2 // EnumDescriptorProto.Value
 	serviceMethodPath = 2 // ServiceDescriptorProto.Method
 )
@@ -0,0 +1,77 @@
+package generate
+
+import (
+	"fmt"
+
+	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/generate/downloader"
+)
+
+// protocConfig is the protoc binary a package is configured to use, as set in
+// the [protoc] section of its .gunkconfig.
+type protocConfig struct {
+	path    string
+	version string
+}
+
+// resolveProtocs checks for or downloads the protoc binaries needed to
+// generate the packages, and returns their paths by package. Only packages
+// with generators builtin to protoc need one, be it their own generators or
+// generate_single ones. Each distinct protoc config is resolved once, no
+// matter how many packages use it.
+func (g *Generator) resolveProtocs(paths []string, gens map[string][]config.Generator, protocs map[string]protocConfig) (map[string]string, error) {
+	needed := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		for _, gen := range gens[path] {
+			switch {
+			case !gen.IsProtoc():
+			case !gen.Single:
+				needed[path] = true
+			default:
+				files, err := g.singlePkgs(gen, paths)
+				if err != nil {
+					return nil, err
+				}
+				for _, file := range files {
+					needed[file] = true
+				}
+			}
+		}
+	}
+	resolved := make(map[protocConfig]string)
+	protocPaths := make(map[string]string, len(needed))
+	for _, path := range paths {
+		if !needed[path] {
+			continue
+		}
+		pc := protocs[path]
+		protocPath, ok := resolved[pc]
+		if !ok {
+			var err error
+			protocPath, err = downloader.CheckOrDownloadProtoc(g.Logger, pc.path, pc.version)
+			if err != nil {
+				return nil, fmt.Errorf("unable to check or download protoc for pkg %s: %w", path, err)
+			}
+			resolved[pc] = protocPath
+		}
+		protocPaths[path] = protocPath
+	}
+	return protocPaths, nil
+}
+
+// singleProtocPath returns the protoc binary to run a generate_single
+// generator builtin to protoc with. As it runs once for all the packages, they
+// must all be configured to use the same protoc.
+func singleProtocPath(files []string, protocPaths map[string]string) (string, error) {
+	var first string
+	for _, file := range files {
+		switch {
+		case first == "":
+			first = file
+		case protocPaths[file] != protocPaths[first]:
+			return "", fmt.Errorf("packages %s and %s need different protoc binaries: %s and %s",
+				first, file, protocPaths[first], protocPaths[file])
+		}
+	}
+	return protocPaths[first], nil
+}
@@ -0,0 +1,53 @@
+package generate
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gunk/gunk/watch"
+)
+
+// Watch generates the specified Gunk packages like Run, and then watches the
+// .gunk and .gunkconfig files under dir until ctx is done. Every time they
+// change, the affected packages and the packages importing them are generated
+// again. Errors are printed rather than returned, so that watching goes on
+// until they are fixed.
+func Watch(ctx context.Context, dir string, args ...string) error {
+	return New(Options{Dir: dir, Logger: stderrLogger()}).Watch(ctx, args...)
+}
+
+// Watch generates and watches the specified Gunk packages like the Watch
+// function, with the options of the Generator. Each generation uses a new
+// Generator with the same options, but they all share the loader of g, so
+// that only the affected packages are loaded again.
+func (g *Generator) Watch(ctx context.Context, args ...string) error {
+	cache, err := openCache()
+	if err != nil {
+		return err
+	}
+	dir, logger := g.opts.Dir, g.opts.Logger
+	return watch.Run(ctx, dir, logger, func(changed []string) error {
+		patterns := args
+		if changed != nil {
+			affected, err := watch.Affected(&g.Loader, args, changed, true)
+			if err != nil {
+				return err
+			}
+			if len(affected) == 0 {
+				return nil
+			}
+			patterns = affected
+		}
+		gen := New(g.opts)
+		gen.Loader = g.Loader
+		gen.cache = cache
+		err := gen.generate(ctx, patterns...)
+		// Keep the packages loaded by the generation.
+		g.Loader = gen.Loader
+		if err != nil {
+			return err
+		}
+		logger.Printf("generated %s", strings.Join(patterns, " "))
+		return nil
+	})
+}
@@ -1,28 +1,30 @@
 module github.com/gunk/gunk
 
-go 1.19
+go 1.21
 
 require (
+	github.com/bufbuild/protocompile v0.6.0
 	github.com/emicklei/proto v1.11.0
 	github.com/grpc-ecosystem/grpc-gateway/v2 v2.11.3
 	github.com/gunk/opt v0.3.1
 	github.com/karelbilek/dirchanges v0.0.0-20210218071031-880a92f1a313
 	github.com/kenshaw/ini v0.5.1
 	github.com/kenshaw/snaker v0.2.0
+	github.com/pkg/diff v0.0.0-20210226163009-20ebb0f2a09e
 	github.com/rogpeppe/go-internal v1.9.0
 	github.com/spf13/cobra v1.5.0
 	github.com/xo/ecosystem v0.0.0-20220523112515-ac4bb89e7920
 	golang.org/x/sys v0.0.0-20220919091848-fb04ddd9f9c8
 	golang.org/x/tools v0.1.12
 	google.golang.org/genproto v0.0.0-20220923205249-dd2d53f1fffc
-	google.golang.org/protobuf v1.28.1
+	google.golang.org/protobuf v1.31.0
 	mvdan.cc/gofumpt v0.3.1
 )
 
 require (
 	github.com/google/go-cmp v0.5.9 // indirect
 	github.com/inconshreveable/mousetrap v1.0.1 // indirect
-	github.com/pkg/diff v0.0.0-20210226163009-20ebb0f2a09e // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	golang.org/x/mod v0.6.0-dev.0.20220419223038-86c51ed26bb4 // indirect
+	golang.org/x/sync v0.3.0 // indirect
 )
@@ -3,6 +3,7 @@ package lint
 import (
 	"go/ast"
 	"go/types"
+	"strconv"
 	"strings"
 
 	"github.com/gunk/gunk/loader"
@@ -12,6 +13,11 @@ import (
 // object they're describing or end with a period.
 func lintComment(l *Linter, pkgs []*loader.GunkPackage) {
 	for _, pkg := range pkgs {
+		requireIs := true
+		if v, ok := l.setting(pkg, "comment", "require_is"); ok {
+			// The value was checked when loading the config.
+			requireIs, _ = strconv.ParseBool(v)
+		}
 		for _, f := range pkg.GunkSyntax {
 			ast.Inspect(f, func(n ast.Node) bool {
 				switch v := n.(type) {
@@ -20,7 +26,7 @@ func lintComment(l *Linter, pkgs []*loader.GunkPackage) {
 				case *ast.File, *ast.GenDecl, *ast.StructType, *ast.InterfaceType, *ast.FieldList:
 					return true
 				case *ast.TypeSpec:
-					checkComment(l, n, v.Name.Name, v.Doc.Text(), true)
+					checkComment(l, n, v.Name.Name, v.Doc.Text(), requireIs)
 					return true
 				case *ast.Field:
 					if len(v.Names) != 1 {
@@ -28,7 +34,7 @@ func lintComment(l *Linter, pkgs []*loader.GunkPackage) {
 					}
 					typ := pkg.TypesInfo.TypeOf(v.Names[0])
 					_, isMethod := typ.(*types.Signature)
-					checkComment(l, n, v.Names[0].Name, v.Doc.Text(), !isMethod)
+					checkComment(l, n, v.Names[0].Name, v.Doc.Text(), requireIs && !isMethod)
 					return true
 				}
 			})
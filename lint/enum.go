@@ -0,0 +1,114 @@
+package lint
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strings"
+	"unicode"
+
+	"github.com/gunk/gunk/loader"
+)
+
+// enum is an enum type and its values, in the order they are declared.
+type enum struct {
+	spec   *ast.TypeSpec
+	values []*ast.Ident
+}
+
+// pkgEnums returns the enums declared in a package, in the order they are
+// declared.
+func pkgEnums(pkg *loader.GunkPackage) []*enum {
+	var enums []*enum
+	byType := make(map[types.Type]*enum)
+	for _, f := range pkg.GunkSyntax {
+		for _, decl := range f.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				obj := pkg.TypesInfo.Defs[spec.Name]
+				if obj == nil {
+					continue
+				}
+				basic, ok := obj.Type().Underlying().(*types.Basic)
+				if !ok || basic.Info()&types.IsInteger == 0 {
+					continue
+				}
+				e := &enum{spec: spec}
+				enums = append(enums, e)
+				byType[obj.Type()] = e
+			}
+		}
+	}
+	for _, f := range pkg.GunkSyntax {
+		for _, decl := range f.Decls {
+			decl, ok := decl.(*ast.GenDecl)
+			if !ok || decl.Tok != token.CONST {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					obj := pkg.TypesInfo.Defs[name]
+					if obj == nil {
+						continue
+					}
+					if e := byType[obj.Type()]; e != nil {
+						e.values = append(e.values, name)
+					}
+				}
+			}
+		}
+	}
+	return enums
+}
+
+// lintEnumZero reports the enums whose first value isn't <Enum>Unspecified,
+// with the value 0.
+func lintEnumZero(l *Linter, pkgs []*loader.GunkPackage) {
+	for _, pkg := range pkgs {
+		for _, e := range pkgEnums(pkg) {
+			want := e.spec.Name.Name + "Unspecified"
+			if len(e.values) == 0 {
+				l.addError(e.spec, "enum %s must have a first value %s = 0", e.spec.Name.Name, want)
+				continue
+			}
+			first := e.values[0]
+			val := pkg.TypesInfo.Defs[first].(*types.Const).Val()
+			if first.Name != want || constant.Sign(val) != 0 {
+				l.addError(first, "first value of enum %s must be %s = 0", e.spec.Name.Name, want)
+			}
+		}
+	}
+}
+
+// lintEnumNaming reports the enum values which aren't in PascalCase, or
+// aren't prefixed by the name of their enum.
+func lintEnumNaming(l *Linter, pkgs []*loader.GunkPackage) {
+	for _, pkg := range pkgs {
+		for _, e := range pkgEnums(pkg) {
+			for _, v := range e.values {
+				switch {
+				case !isPascalCase(v.Name):
+					l.addError(v, "enum value %s must be PascalCase", v.Name)
+				case !strings.HasPrefix(v.Name, e.spec.Name.Name):
+					l.addError(v, "enum value %s must be prefixed by the name of its enum %s", v.Name, e.spec.Name.Name)
+				}
+			}
+		}
+	}
+}
+
+// isPascalCase reports whether name starts with an upper case letter and has
+// no underscores.
+func isPascalCase(name string) bool {
+	for i, r := range name {
+		if i == 0 && !unicode.IsUpper(r) || r == '_' {
+			return false
+		}
+	}
+	return name != ""
+}
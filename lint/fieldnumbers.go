@@ -0,0 +1,92 @@
+package lint
+
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/gunk/gunk/loader"
+)
+
+// The range of field numbers reserved by the protobuf implementation.
+const (
+	firstImplReserved = 19000
+	lastImplReserved  = 19999
+)
+
+// lintFieldNumbers reports the fields whose numbers leave a gap after the
+// previous number, other than reserved numbers, or are in the range
+// 19000-19999 reserved by protobuf.
+func lintFieldNumbers(l *Linter, pkgs []*loader.GunkPackage) {
+	for _, pkg := range pkgs {
+		for _, f := range pkg.GunkSyntax {
+			for _, decl := range f.Decls {
+				decl, ok := decl.(*ast.GenDecl)
+				if !ok || decl.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					tspec := spec.(*ast.TypeSpec)
+					st, ok := tspec.Type.(*ast.StructType)
+					if !ok {
+						continue
+					}
+					// Invalid annotations are reported by the loader.
+					reserved, _ := loader.DeclReserved(l.Fset, pkg, f, decl, tspec)
+					checkFieldNumbers(l, st, reserved)
+				}
+			}
+		}
+	}
+}
+
+func checkFieldNumbers(l *Linter, st *ast.StructType, reserved *loader.Reserved) {
+	type numbered struct {
+		field  *ast.Field
+		number int
+	}
+	var fields []numbered
+	for _, field := range st.Fields.List {
+		if field.Tag == nil || len(field.Names) != 1 {
+			continue
+		}
+		tag, err := strconv.Unquote(field.Tag.Value)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.Atoi(reflect.StructTag(tag).Get("pb"))
+		if err != nil {
+			// Missing numbers are set by gunk format.
+			continue
+		}
+		fields = append(fields, numbered{field, n})
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].number < fields[j].number })
+	next := nextFieldNumber(reserved, 1)
+	for _, f := range fields {
+		name := f.field.Names[0].Name
+		switch {
+		case f.number >= firstImplReserved && f.number <= lastImplReserved:
+			l.addError(f.field, "field %s uses number %d, in the range %d-%d reserved by protobuf", name, f.number, firstImplReserved, lastImplReserved)
+		case f.number > next:
+			l.addError(f.field, "field %s has number %d, leaving %d unused", name, f.number, next)
+		}
+		if f.number >= next {
+			next = nextFieldNumber(reserved, f.number+1)
+		}
+	}
+}
+
+// nextFieldNumber returns the first field number from n onwards which isn't
+// reserved by the message or by protobuf.
+func nextFieldNumber(reserved *loader.Reserved, n int) int {
+	for {
+		n = reserved.NextUnreserved(n)
+		if n < firstImplReserved || n > lastImplReserved {
+			return n
+		}
+		n = lastImplReserved + 1
+	}
+}
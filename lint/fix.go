@@ -0,0 +1,168 @@
+package lint
+
+import (
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gunk/gunk/format"
+)
+
+// textEdit is a suggested replacement of the source between pos and end.
+type textEdit struct {
+	pos, end token.Pos
+	newText  string
+}
+
+// applyFixes applies the edits suggested by the errors to the Gunk files, and
+// removes the errors that were fixed. The fixed files are then formatted like
+// gunk format does.
+//
+// Edits which overlap an edit applied before them are skipped, leaving their
+// errors to be reported.
+func (l *Linter) applyFixes() error {
+	// Group the errors with edits by file.
+	byFile := make(map[string][]*scanner.Error)
+	for _, e := range l.Err {
+		if len(l.info[e].edits) > 0 {
+			byFile[e.Pos.Filename] = append(byFile[e.Pos.Filename], e)
+		}
+	}
+	fixed := make(map[*scanner.Error]bool)
+	for filename, errs := range byFile {
+		src, err := l.source(filename)
+		if err != nil {
+			return err
+		}
+		var edits []textEdit
+		for _, e := range errs {
+			if !canApply(edits, l.info[e].edits) {
+				continue
+			}
+			for _, edit := range l.info[e].edits {
+				if !containsEdit(edits, edit) {
+					edits = append(edits, edit)
+				}
+			}
+			fixed[e] = true
+		}
+		// Apply the edits from the end, so that the offsets of the ones
+		// before stay valid.
+		sort.Slice(edits, func(i, j int) bool { return edits[i].pos > edits[j].pos })
+		for _, edit := range edits {
+			start, end := l.Fset.Position(edit.pos).Offset, l.Fset.Position(edit.end).Offset
+			src = append(src[:start:start], append([]byte(edit.newText), src[end:]...)...)
+		}
+		f, err := format.New(l.cfg[l.pkgOf[filename]])
+		if err != nil {
+			return fmt.Errorf("unable to initialize formatter: %w", err)
+		}
+		if src, err = f.Source(src); err != nil {
+			return fmt.Errorf("unable to format fixed %s: %w", filename, err)
+		}
+		if err := ioutil.WriteFile(filename, src, 0o666); err != nil {
+			return err
+		}
+	}
+	var remaining scanner.ErrorList
+	for _, e := range l.Err {
+		if !fixed[e] {
+			remaining = append(remaining, e)
+		}
+	}
+	l.Err = remaining
+	return nil
+}
+
+// source returns the source of a Gunk file.
+func (l *Linter) source(filename string) ([]byte, error) {
+	if src, ok := l.Overlay[filename]; ok {
+		return append([]byte(nil), src...), nil
+	}
+	return ioutil.ReadFile(filename)
+}
+
+// canApply returns whether none of the new edits overlap the edits, other
+// than being the same edit.
+func canApply(edits, newEdits []textEdit) bool {
+	for _, n := range newEdits {
+		for _, e := range edits {
+			if n == e {
+				continue
+			}
+			if n.pos < e.end && e.pos < n.end || n.pos == e.pos {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func containsEdit(edits []textEdit, edit textEdit) bool {
+	for _, e := range edits {
+		if e == edit {
+			return true
+		}
+	}
+	return false
+}
+
+// tagLiteral returns the literal of a struct tag, quoted like the literal
+// lit if possible.
+func tagLiteral(lit, tag string) string {
+	if strings.HasPrefix(lit, "`") && !strings.Contains(tag, "`") {
+		return "`" + tag + "`"
+	}
+	return strconv.Quote(tag)
+}
+
+// replaceTag returns the struct tag with the value of key set to value, or
+// added if missing.
+func replaceTag(tag, key, value string) string {
+	var parts []string
+	found := false
+	for _, part := range splitTag(tag) {
+		if strings.HasPrefix(part, key+":") {
+			part = fmt.Sprintf("%s:%q", key, value)
+			found = true
+		}
+		parts = append(parts, part)
+	}
+	if !found {
+		parts = append(parts, fmt.Sprintf("%s:%q", key, value))
+	}
+	return strings.Join(parts, " ")
+}
+
+// splitTag splits a raw struct tag into its key:"value" pairs, keeping any
+// malformed rest as is.
+func splitTag(tag string) []string {
+	var parts []string
+	for {
+		tag = strings.TrimLeft(tag, " ")
+		if tag == "" {
+			return parts
+		}
+		// Find the end of the quoted value, skipping escaped quotes.
+		i := strings.Index(tag, `:"`)
+		if i < 0 {
+			return append(parts, tag)
+		}
+		j := i + 2
+		for j < len(tag) && tag[j] != '"' {
+			if tag[j] == '\\' {
+				j++
+			}
+			j++
+		}
+		if j >= len(tag) {
+			return append(parts, tag)
+		}
+		parts = append(parts, tag[:j+1])
+		tag = tag[j+1:]
+	}
+}
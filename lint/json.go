@@ -1,9 +1,11 @@
 package lint
 
 import (
+	"fmt"
 	"go/ast"
 	"reflect"
 	"strconv"
+	"strings"
 
 	"github.com/gunk/gunk/loader"
 	"github.com/kenshaw/snaker"
@@ -12,7 +14,15 @@ import (
 func lintJSON(l *Linter, pkgs []*loader.GunkPackage) {
 	for _, pkg := range pkgs {
 		s := snaker.NewDefaultInitialisms()
-		err := s.Add(l.cfg[pkg.ID].Format.Initialisms...)
+		initialisms := l.cfg[pkg.ID].Format.Initialisms
+		if v, ok := l.setting(pkg, "json", "initialisms"); ok {
+			for _, i := range strings.Split(v, ",") {
+				if i = strings.TrimSpace(i); i != "" {
+					initialisms = append(initialisms, i)
+				}
+			}
+		}
+		err := s.Add(initialisms...)
 		if err != nil {
 			l.addError(pkg.GunkSyntax[0], "error loading initialisms: %v", err)
 		}
@@ -26,8 +36,22 @@ func lintJSON(l *Linter, pkgs []*loader.GunkPackage) {
 					// Continue walking down the tree for these types.
 					return true
 				case *ast.Field:
+					// The JSON name the field should have, to fix the
+					// tag with.
+					snakeCase := ""
+					if len(v.Names) == 1 {
+						snakeCase = s.CamelToSnakeIdentifier(v.Names[0].Name)
+					}
 					if v.Tag == nil {
-						l.addError(n, "expecting JSON tag, found none")
+						if snakeCase == "" {
+							l.addError(n, "expecting JSON tag, found none")
+							return false
+						}
+						l.addFix(n, []textEdit{{
+							pos:     v.Type.End(),
+							end:     v.Type.End(),
+							newText: fmt.Sprintf(" `json:%q`", snakeCase),
+						}}, "expecting JSON tag, found none")
 						return false
 					}
 					tagValue, err := strconv.Unquote(v.Tag.Value)
@@ -35,19 +59,29 @@ func lintJSON(l *Linter, pkgs []*loader.GunkPackage) {
 						l.addError(n, "invalid struct tag")
 						return false
 					}
+					// fixTag replaces the tag with one with the
+					// right JSON name.
+					fixTag := []textEdit{{
+						pos:     v.Tag.Pos(),
+						end:     v.Tag.End(),
+						newText: tagLiteral(v.Tag.Value, replaceTag(tagValue, "json", snakeCase)),
+					}}
 					tag := reflect.StructTag(tagValue)
 					json, ok := tag.Lookup("json")
 					if !ok {
-						l.addError(n, "expecting JSON tag, found none")
+						if snakeCase == "" {
+							l.addError(n, "expecting JSON tag, found none")
+							return false
+						}
+						l.addFix(n, fixTag, "expecting JSON tag, found none")
 						return false
 					}
 					if len(v.Names) != 1 {
 						l.addError(n, "expected exactly 1 name, got %d", len(v.Names))
 						return false
 					}
-					snakeCase := s.CamelToSnakeIdentifier(v.Names[0].Name)
 					if json != snakeCase {
-						l.addError(n, "JSON name must be snake case of field name")
+						l.addFix(n, fixTag, "JSON name must be snake case of field name")
 						return false
 					}
 				}
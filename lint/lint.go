@@ -7,42 +7,124 @@ import (
 	"go/token"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/diagnostic"
 	"github.com/gunk/gunk/loader"
+	"github.com/gunk/gunk/log"
 )
 
 type linter struct {
 	Usage string
 	Run   func(*Linter, []*loader.GunkPackage)
+	// Default is whether the linter runs when no linters are enabled in
+	// the config or with the command line flags. The other linters are
+	// opt-in.
+	Default bool
+	// Settings are the settings of the linter in the [lint] section of
+	// .gunkconfig, set with keys of the form "linter.setting".
+	Settings map[string]setting
 }
 
-var linters = map[string]linter{
-	"comment": {
-		Usage: "enforces comments to start with the name of the described object and end with a period",
-		Run:   lintComment,
-	},
-	"json": {
-		Usage: "enforces JSON tags to be snake case versions of field name",
-		Run:   lintJSON,
-	},
-	"unimport": {
-		Usage: "lists all imports that are unused",
-		Run:   lintUnimport,
-	},
-	"unused": {
-		Usage: "lists all enums and structs that are unused",
-		Run:   lintUnused,
-	},
+// setting is a setting of a linter.
+type setting struct {
+	Usage string
+	// Check validates the value of the setting, if not nil.
+	Check func(string) error
+}
+
+// checkBool checks that a setting is a boolean.
+func checkBool(v string) error {
+	_, err := strconv.ParseBool(v)
+	return err
+}
+
+// linters holds the available linters by name. It's set in init, as the
+// linters refer to it to check whether they're enabled.
+var linters map[string]linter
+
+func init() {
+	linters = map[string]linter{
+		"comment": {
+			Usage:   "enforces comments to start with the name of the described object and end with a period",
+			Run:     lintComment,
+			Default: true,
+			Settings: map[string]setting{
+				"require_is": {
+					Usage: `whether comments of types and fields must start with "Name is" or "Name are", true by default`,
+					Check: checkBool,
+				},
+			},
+		},
+		"enum_naming": {
+			Usage: "enforces enum values to be PascalCase and prefixed by the name of their enum",
+			Run:   lintEnumNaming,
+		},
+		"enum_zero": {
+			Usage: "enforces the first value of enums to be <Enum>Unspecified = 0",
+			Run:   lintEnumZero,
+		},
+		"field_numbers": {
+			Usage: "enforces field numbers to have no gaps and to avoid the range 19000-19999 reserved by protobuf",
+			Run:   lintFieldNumbers,
+		},
+		"json": {
+			Usage:   "enforces JSON tags to be snake case versions of field name",
+			Run:     lintJSON,
+			Default: true,
+			Settings: map[string]setting{
+				"initialisms": {
+					Usage: "comma-separated initialisms to use in snake case, besides the ones of the format section",
+				},
+			},
+		},
+		nolintName: {
+			Usage:   "lists nolint directives which are malformed or suppress nothing",
+			Default: true,
+		},
+		"rpc_naming": {
+			Usage: "enforces the request and response types of methods to be named <Method>Request and <Method>Response, and used by a single method",
+			Run:   lintRPCNaming,
+		},
+		"unimport": {
+			Usage:   "lists all imports that are unused",
+			Run:     lintUnimport,
+			Default: true,
+		},
+		"unused": {
+			Usage:   "lists all enums and structs that are unused",
+			Run:     lintUnused,
+			Default: true,
+		},
+	}
 }
 
 // Run starts the linter in the provided directory with the specified
 // arguments.
-// If enable is not empty, it is treated as a whitelist.
+// The linters to run for each package are set in the lint section of its
+// .gunkconfig, unless enable or disable are not empty.
+// If enable is not empty, it is treated as a whitelist. Otherwise, only the
+// default linters are run.
 // If disable is not empty, it is treated as a blacklist.
-func Run(dir string, enable string, disable string, args ...string) error {
+// The errors are added to diags, or printed if it's nil.
+// If fix is true, the edits suggested by the errors are applied to the Gunk
+// files, and only the errors which couldn't be fixed are reported.
+func Run(dir string, logger *log.Logger, diags *diagnostic.Printer, enable string, disable string, fix bool, args ...string) error {
+	var enableList, disableList []string
+	if enable != "" {
+		enableList = strings.Split(enable, ",")
+	}
+	if disable != "" {
+		disableList = strings.Split(disable, ",")
+	}
+	if err := checkLinters(enableList, disableList); err != nil {
+		return err
+	}
 	l := New(dir)
+	l.Logger = logger
+	l.Diagnostics = diags
 	pkgs, err := l.Load(args...)
 	if err != nil {
 		return fmt.Errorf("error loading packages: %w", err)
@@ -50,44 +132,46 @@ func Run(dir string, enable string, disable string, args ...string) error {
 	if len(pkgs) == 0 {
 		return fmt.Errorf("no Gunk packages to lint")
 	}
-	if loader.PrintErrors(pkgs) > 0 {
+	if l.ReportErrors(logger.ErrorWriter(), pkgs) > 0 {
 		return fmt.Errorf("encountered package loading errors")
 	}
-	// Decide linters to run
-	lintersToRun := make(map[string]linter, len(linters))
-	if enable == "" {
-		for k, v := range linters {
-			lintersToRun[k] = v
-		}
-	} else {
-		for _, name := range strings.Split(enable, ",") {
-			linter, ok := linters[name]
-			if !ok {
-				return fmt.Errorf("unknown linter: %q", name)
-			}
-			lintersToRun[name] = linter
-		}
-	}
-	if disable != "" {
-		for _, name := range strings.Split(disable, ",") {
-			_, ok := linters[name]
-			if !ok {
-				return fmt.Errorf("unknown linter: %q", name)
-			}
-			delete(lintersToRun, name)
-		}
-	}
 	// Load package configs
 	for _, pkg := range pkgs {
 		cfg, err := config.Load(pkg.Dir)
 		if err != nil {
-			return fmt.Errorf("error loading config for %s: %w", dir, err)
+			return fmt.Errorf("error loading config for %s: %w", pkg.Dir, err)
+		}
+		// The command line flags take precedence over the config.
+		if enableList != nil {
+			cfg.Lint.Enable = enableList
+		}
+		if disableList != nil {
+			cfg.Lint.Disable = disableList
+		}
+		if err := checkConfig(cfg.Lint); err != nil {
+			return fmt.Errorf("error loading config for %s: %w", pkg.Dir, err)
 		}
 		l.cfg[pkg.ID] = cfg
+		for _, name := range pkg.GunkFiles {
+			l.pkgOf[name] = pkg.ID
+		}
 	}
-	// Run the linters
-	for _, v := range lintersToRun {
-		v.Run(l, pkgs)
+	l.loadDirectives(pkgs)
+	// Run the linters enabled for any of the packages, sorted by name. The
+	// nolint directives are checked last, once they've been used.
+	for _, name := range linterNames() {
+		if name == nolintName || !l.enabledForAny(name) {
+			continue
+		}
+		l.current = name
+		linters[name].Run(l, pkgs)
+	}
+	l.current = nolintName
+	l.checkDirectives()
+	if fix {
+		if err := l.applyFixes(); err != nil {
+			return fmt.Errorf("unable to fix: %w", err)
+		}
 	}
 	if l.PrintErrors() > 0 {
 		return fmt.Errorf("encountered linting errors")
@@ -95,12 +179,65 @@ func Run(dir string, enable string, disable string, args ...string) error {
 	return nil
 }
 
+// checkLinters checks that the linters exist.
+func checkLinters(lists ...[]string) error {
+	for _, list := range lists {
+		for _, name := range list {
+			if _, ok := linters[name]; !ok {
+				return fmt.Errorf("unknown linter: %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// checkConfig checks that the linters and settings in a lint config exist,
+// and that the settings are valid.
+func checkConfig(cfg config.LintConfig) error {
+	if err := checkLinters(cfg.Enable, cfg.Disable); err != nil {
+		return err
+	}
+	for name, settings := range cfg.Settings {
+		linter, ok := linters[name]
+		if !ok {
+			return fmt.Errorf("unknown linter: %q", name)
+		}
+		for k, v := range settings {
+			s, ok := linter.Settings[k]
+			if !ok {
+				return fmt.Errorf("unknown setting %q for linter %q", k, name)
+			}
+			if s.Check == nil {
+				continue
+			}
+			if err := s.Check(v); err != nil {
+				return fmt.Errorf("invalid setting %s.%s: %w", name, k, err)
+			}
+		}
+	}
+	return nil
+}
+
 // Linter is a struct that holds the state of the linter.
 type Linter struct {
 	*loader.Loader
 	Err scanner.ErrorList
 
 	cfg map[string]*config.Config
+	// pkgOf holds the ID of the package of each Gunk file.
+	pkgOf map[string]string
+	// current is the name of the linter being run.
+	current    string
+	directives map[string][]*directive
+	// info holds the linter and the end position of each error in Err.
+	info map[*scanner.Error]errorInfo
+}
+
+type errorInfo struct {
+	linter string
+	end    token.Position
+	// edits are the suggested edits to fix the error, if any.
+	edits []textEdit
 }
 
 // New creates a new initialized linter instance.
@@ -111,36 +248,132 @@ func New(dir string) *Linter {
 			Fset:  token.NewFileSet(),
 			Types: true,
 		},
-		Err: make(scanner.ErrorList, 0),
-		cfg: make(map[string]*config.Config),
+		Err:        make(scanner.ErrorList, 0),
+		cfg:        make(map[string]*config.Config),
+		pkgOf:      make(map[string]string),
+		directives: make(map[string][]*directive),
+		info:       make(map[*scanner.Error]errorInfo),
 	}
 }
 
-// PrintErrors print the errors the linter accumulated and returns the amount
-// of errors that have been printed.
+// PrintErrors print the errors the linter accumulated, sorted by position,
+// and returns the amount of errors that have been printed. If l.Diagnostics
+// is set, the errors are added to it instead.
 func (l Linter) PrintErrors() int {
+	l.Err.Sort()
 	for _, v := range l.Err {
-		fmt.Fprintln(os.Stderr, v)
+		if l.Diagnostics == nil {
+			fmt.Fprintln(os.Stderr, v)
+			continue
+		}
+		info := l.info[v]
+		severity := diagnostic.Error
+		if info.linter == nolintName {
+			severity = diagnostic.Warning
+		}
+		d := diagnostic.FromError(info.linter, severity, v)
+		d.EndLine, d.EndColumn = info.end.Line, info.end.Column
+		l.Diagnostics.Add(d)
 	}
 	return len(l.Err)
 }
 
 func (l *Linter) addError(n ast.Node, formatStr string, args ...interface{}) {
-	l.Err.Add(l.Fset.Position(n.Pos()), fmt.Sprintf(formatStr, args...))
+	l.addErrorAt(l.Fset.Position(n.Pos()), l.Fset.Position(n.End()), formatStr, args...)
+}
+
+// addFix is like addError, but attaches the edits to fix the error, applied
+// with --fix.
+func (l *Linter) addFix(n ast.Node, edits []textEdit, formatStr string, args ...interface{}) {
+	n0 := len(l.Err)
+	l.addError(n, formatStr, args...)
+	if len(l.Err) > n0 {
+		info := l.info[l.Err[n0]]
+		info.edits = edits
+		l.info[l.Err[n0]] = info
+	}
+}
+
+// addErrorAt adds an error of the current linter from pos to end, unless the
+// linter is disabled for the package of the file, or a nolint directive
+// suppresses it.
+func (l *Linter) addErrorAt(pos, end token.Position, formatStr string, args ...interface{}) {
+	if !l.enabled(pos.Filename, l.current) || l.suppressed(pos) {
+		return
+	}
+	l.Err.Add(pos, fmt.Sprintf(formatStr, args...))
+	l.info[l.Err[len(l.Err)-1]] = errorInfo{linter: l.current, end: end}
+}
+
+// enabled returns whether the linter is enabled for the package of the file.
+func (l *Linter) enabled(filename, name string) bool {
+	cfg := l.cfg[l.pkgOf[filename]]
+	if cfg == nil {
+		return linters[name].Default
+	}
+	switch {
+	case len(cfg.Lint.Enable) > 0 && !containsString(cfg.Lint.Enable, name):
+		return false
+	case len(cfg.Lint.Enable) == 0 && !linters[name].Default:
+		return false
+	}
+	return !containsString(cfg.Lint.Disable, name)
+}
+
+// enabledForAny returns whether the linter is enabled for any package.
+func (l *Linter) enabledForAny(name string) bool {
+	for filename := range l.pkgOf {
+		if l.enabled(filename, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// setting returns the value of a setting of a linter for the package, and
+// whether it's set.
+func (l *Linter) setting(pkg *loader.GunkPackage, linter, name string) (string, bool) {
+	v, ok := l.cfg[pkg.ID].Lint.Settings[linter][name]
+	return v, ok
+}
+
+// linterNames returns the names of all linters, sorted.
+func linterNames() []string {
+	names := make([]string, 0, len(linters))
+	for name := range linters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
 // PrintLinters prints out all linters to stdout.
 func PrintLinters() {
 	fmt.Println("Linters available:")
-	// Sort the name of the linters before displaying.
-	keys := make([]string, 0, len(linters))
-	for k := range linters {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-	// Print the linter.
-	for _, k := range keys {
+	// Print the linters and their settings, sorted by name.
+	for _, k := range linterNames() {
 		v := linters[k]
-		fmt.Printf("\t%-10s - %s\n", k, v.Usage)
+		usage := v.Usage
+		if !v.Default {
+			usage += " (opt-in)"
+		}
+		fmt.Printf("\t%-14s - %s\n", k, usage)
+		settings := make([]string, 0, len(v.Settings))
+		for name := range v.Settings {
+			settings = append(settings, name)
+		}
+		sort.Strings(settings)
+		for _, name := range settings {
+			fmt.Printf("\t\t%s.%s - %s\n", k, name, v.Settings[name].Usage)
+		}
+	}
+}
+
+func containsString(slice []string, str string) bool {
+	for _, s := range slice {
+		if s == str {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,167 @@
+package lint
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"github.com/gunk/gunk/loader"
+)
+
+// nolintName is the name of the linter reporting nolint directives which are
+// malformed or suppress nothing.
+const nolintName = "nolint"
+
+// directivePrefix is the prefix of nolint directives. It follows the syntax
+// of Go directives, so it's not part of the documentation of the declaration
+// it's on. The loader rewrites the directives written with a space after the
+// slashes to this syntax.
+const directivePrefix = "//gunk:nolint"
+
+// directive is a nolint directive, suppressing the errors of some linters on
+// the lines of a declaration.
+type directive struct {
+	pos, endPos token.Position
+	// start and end are the lines of the declaration.
+	start, end int
+	// names are the linters to suppress, or all of them if nil.
+	names []string
+	// used holds the linters which had errors suppressed.
+	used map[string]bool
+}
+
+// loadDirectives finds the nolint directives in the Gunk files of pkgs.
+//
+// A directive on its own line applies to the declaration starting on the next
+// line, while a trailing one applies to the declaration it follows. In both
+// cases it covers all of the lines of the declaration, such as the fields of
+// a struct.
+func (l *Linter) loadDirectives(pkgs []*loader.GunkPackage) {
+	for _, pkg := range pkgs {
+		for _, f := range pkg.GunkSyntax {
+			var decls []ast.Node
+			ast.Inspect(f, func(n ast.Node) bool {
+				switch n.(type) {
+				case *ast.GenDecl, *ast.TypeSpec, *ast.ValueSpec, *ast.ImportSpec, *ast.Field:
+					decls = append(decls, n)
+				}
+				return true
+			})
+			for _, cg := range f.Comments {
+				for _, c := range cg.List {
+					names, ok := parseDirective(c.Text)
+					if !ok {
+						continue
+					}
+					d := &directive{
+						pos:    l.Fset.Position(c.Pos()),
+						endPos: l.Fset.Position(c.End()),
+						names:  names,
+						used:   make(map[string]bool),
+					}
+					d.start, d.end = l.directiveLines(decls, c)
+					l.directives[d.pos.Filename] = append(l.directives[d.pos.Filename], d)
+				}
+			}
+		}
+	}
+}
+
+// directiveLines returns the lines of the declaration a directive applies to.
+func (l *Linter) directiveLines(decls []ast.Node, c *ast.Comment) (start, end int) {
+	line := l.Fset.Position(c.Pos()).Line
+	target := 0
+	for _, n := range decls {
+		nline := l.Fset.Position(n.Pos()).Line
+		if nline == line && n.Pos() < c.Pos() {
+			// A trailing directive.
+			target = line
+			break
+		}
+		if n.Pos() > c.End() && (target == 0 || nline < target) {
+			target = nline
+		}
+	}
+	if target == 0 {
+		return 0, 0
+	}
+	// Use the outermost declaration starting on the line.
+	end = target
+	for _, n := range decls {
+		if l.Fset.Position(n.Pos()).Line != target {
+			continue
+		}
+		if nend := l.Fset.Position(n.End()).Line; nend > end {
+			end = nend
+		}
+	}
+	return target, end
+}
+
+// parseDirective parses a comment of the form "//gunk:nolint" or
+// "//gunk:nolint:name1,name2", optionally followed by a space and an
+// explanation, and returns the linter names in it.
+func parseDirective(text string) (names []string, ok bool) {
+	if !strings.HasPrefix(text, directivePrefix) {
+		return nil, false
+	}
+	rest := text[len(directivePrefix):]
+	switch {
+	case rest == "" || rest[0] == ' ' || rest[0] == '\t':
+		return nil, true
+	case rest[0] == ':':
+		list := strings.Fields(rest[1:])
+		if len(list) == 0 {
+			return []string{}, true
+		}
+		return strings.Split(list[0], ","), true
+	}
+	return nil, false
+}
+
+// suppressed returns whether the current linter's errors at pos are
+// suppressed by a nolint directive, and marks the directive as used.
+func (l *Linter) suppressed(pos token.Position) bool {
+	if l.current == nolintName {
+		return false
+	}
+	for _, d := range l.directives[pos.Filename] {
+		if pos.Line < d.start || pos.Line > d.end {
+			continue
+		}
+		if d.names == nil || containsString(d.names, l.current) {
+			d.used[l.current] = true
+			return true
+		}
+	}
+	return false
+}
+
+// checkDirectives reports the nolint directives which are malformed or
+// suppress nothing. It must run after the other linters.
+func (l *Linter) checkDirectives() {
+	for _, ds := range l.directives {
+		for _, d := range ds {
+			switch {
+			case d.names == nil:
+				if len(d.used) == 0 {
+					l.addErrorAt(d.pos, d.endPos, "unused nolint directive")
+				}
+				continue
+			case len(d.names) == 0:
+				l.addErrorAt(d.pos, d.endPos, "nolint directive names no linters")
+				continue
+			}
+			for _, name := range d.names {
+				switch _, ok := linters[name]; {
+				case !ok:
+					l.addErrorAt(d.pos, d.endPos, "unknown linter %q in nolint directive", name)
+				case name == nolintName:
+					// The directives can't suppress their own errors.
+				case !d.used[name] && l.enabled(d.pos.Filename, name):
+					l.addErrorAt(d.pos, d.endPos, "unused nolint directive for %s", name)
+				}
+			}
+		}
+	}
+}
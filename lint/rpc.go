@@ -0,0 +1,73 @@
+package lint
+
+import (
+	"go/ast"
+	"go/types"
+
+	"github.com/gunk/gunk/loader"
+)
+
+// lintRPCNaming reports the request and response types of methods which
+// aren't named <Method>Request and <Method>Response, or which are used by
+// more than one method.
+func lintRPCNaming(l *Linter, pkgs []*loader.GunkPackage) {
+	for _, pkg := range pkgs {
+		// The first method using each request or response type.
+		usedBy := make(map[types.Type]string)
+		check := func(method string, list *ast.FieldList, kind, suffix string) {
+			if list == nil || len(list.List) != 1 {
+				// No parameters or results use google.protobuf.Empty.
+				return
+			}
+			expr := list.List[0].Type
+			if ch, ok := expr.(*ast.ChanType); ok {
+				// A stream of messages.
+				expr = ch.Value
+			}
+			var name string
+			switch expr := expr.(type) {
+			case *ast.Ident:
+				name = expr.Name
+			case *ast.SelectorExpr:
+				name = expr.Sel.Name
+			}
+			if want := method + suffix; name != want {
+				l.addError(expr, "%s type of %s must be named %s", kind, method, want)
+			}
+			typ := pkg.TypesInfo.TypeOf(expr)
+			if typ == nil {
+				return
+			}
+			if other, ok := usedBy[typ]; ok {
+				l.addError(expr, "%s type of %s is also used by %s", kind, method, other)
+				return
+			}
+			usedBy[typ] = method
+		}
+		for _, f := range pkg.GunkSyntax {
+			ast.Inspect(f, func(n ast.Node) bool {
+				switch v := n.(type) {
+				default:
+					return false
+				case *ast.File, *ast.GenDecl:
+					return true
+				case *ast.TypeSpec:
+					iface, ok := v.Type.(*ast.InterfaceType)
+					if !ok {
+						return false
+					}
+					for _, m := range iface.Methods.List {
+						fn, ok := m.Type.(*ast.FuncType)
+						if !ok || len(m.Names) != 1 {
+							continue
+						}
+						method := m.Names[0].Name
+						check(method, fn.Params, "request", "Request")
+						check(method, fn.Results, "response", "Response")
+					}
+					return false
+				}
+			})
+		}
+	}
+}
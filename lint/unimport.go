@@ -2,6 +2,7 @@ package lint
 
 import (
 	"go/ast"
+	"go/token"
 	"go/types"
 	"strconv"
 
@@ -40,13 +41,41 @@ func lintUnimport(l *Linter, pkgs []*loader.GunkPackage) {
 					addType(v.Type)
 				}
 			}
-			for _, v := range f.Imports {
-				importPath, err := strconv.Unquote(v.Path.Value)
-				if err != nil {
-					l.addError(v, "failed to parse import %q", v.Path.Value)
+			for _, decl := range f.Decls {
+				decl, ok := decl.(*ast.GenDecl)
+				if !ok || decl.Tok != token.IMPORT {
+					continue
 				}
-				if !usedImports[importPath] {
-					l.addError(v, "unused import %s", importPath)
+				var unused []*ast.ImportSpec
+				for _, spec := range decl.Specs {
+					v := spec.(*ast.ImportSpec)
+					importPath, err := strconv.Unquote(v.Path.Value)
+					if err != nil {
+						l.addError(v, "failed to parse import %q", v.Path.Value)
+					}
+					if !usedImports[importPath] {
+						unused = append(unused, v)
+					}
+				}
+				for _, v := range unused {
+					// Remove the whole declaration if none of its
+					// imports are used, as "import ()" is left
+					// otherwise.
+					edit := textEdit{pos: v.Pos(), end: v.End()}
+					if v.Doc != nil {
+						edit.pos = v.Doc.Pos()
+					}
+					if v.Comment != nil {
+						edit.end = v.Comment.End()
+					}
+					if len(unused) == len(decl.Specs) {
+						edit = textEdit{pos: decl.Pos(), end: decl.End()}
+						if decl.Doc != nil {
+							edit.pos = decl.Doc.Pos()
+						}
+					}
+					importPath, _ := strconv.Unquote(v.Path.Value)
+					l.addFix(v, []textEdit{edit}, "unused import %s", importPath)
 				}
 			}
 		}
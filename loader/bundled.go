@@ -0,0 +1,134 @@
+package loader
+
+import (
+	"context"
+	"encoding/json"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gunk/gunk/assets"
+	"golang.org/x/tools/go/packages"
+)
+
+// optModule is the module of the Gunk annotations.
+const optModule = "github.com/gunk/opt"
+
+// downloadModule downloads the module required by the main module into the
+// module cache, and returns its directory, or an empty string if it can't be
+// downloaded. The errors are left to be reported when loading the packages.
+func (l *Loader) downloadModule(path string) string {
+	cmd := l.Logger.CommandContext(context.Background(), "go", "mod", "download", "-json", path)
+	cmd.Dir = l.Dir
+	out, err := l.Logger.Output(cmd)
+	if err != nil {
+		return ""
+	}
+	var mod struct{ Dir string }
+	if err := json.Unmarshal(out, &mod); err != nil {
+		return ""
+	}
+	return mod.Dir
+}
+
+// addBundledFiles adds the Gunk files of assets.Opt to l.bundled, at their path
+// in the github.com/gunk/opt module found in dir. A file is skipped if it
+// exists, or if the module's package already declares any of its types, so
+// that a newer version of the module declaring them takes precedence.
+func (l *Loader) addBundledFiles(dir string) error {
+	l.bundled = make(map[string][]byte)
+	declared := make(map[string]map[string]bool) // by package dir
+	return fs.WalkDir(assets.Opt, "opt", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		src, err := assets.Opt.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), name, src, 0)
+		if err != nil {
+			return err
+		}
+		pkgDir := filepath.Join(dir, filepath.FromSlash(path.Dir(strings.TrimPrefix(name, "opt/"))))
+		target := filepath.Join(pkgDir, path.Base(name))
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		if declared[pkgDir] == nil {
+			declared[pkgDir] = declaredTypes(pkgDir)
+		}
+		for _, typ := range typeNames(file) {
+			if declared[pkgDir][typ] {
+				return nil
+			}
+		}
+		l.bundled[target] = src
+		return nil
+	})
+}
+
+// declaredTypes returns the names of the types declared by the Gunk files in
+// dir. Files which can't be parsed are ignored, as they are reported when the
+// package is loaded.
+func declaredTypes(dir string) map[string]bool {
+	names := make(map[string]bool)
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.gunk"))
+	for _, path := range paths {
+		file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+		if err != nil {
+			continue
+		}
+		for _, name := range typeNames(file) {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// typeNames returns the names of the types declared in the file.
+func typeNames(file *ast.File) []string {
+	var names []string
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			names = append(names, spec.(*ast.TypeSpec).Name.Name)
+		}
+	}
+	return names
+}
+
+// bundledPackage returns the package if the patterns are the import path of
+// a package with only bundled Gunk files, as its directory doesn't exist in
+// the module, or nil otherwise.
+func (l *Loader) bundledPackage(patterns ...string) *GunkPackage {
+	if len(patterns) != 1 || l.optDir == "" || !strings.HasPrefix(patterns[0], optModule+"/") {
+		return nil
+	}
+	pkgPath := patterns[0]
+	dir := filepath.Join(l.optDir, filepath.FromSlash(strings.TrimPrefix(pkgPath, optModule+"/")))
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	pkg := &GunkPackage{
+		Package: packages.Package{
+			ID:      pkgPath,
+			Name:    "", // will be filled later
+			PkgPath: pkgPath,
+		},
+		Dir: dir,
+	}
+	findGunkFiles(pkg, l.bundled)
+	if len(pkg.GunkFiles) == 0 {
+		return nil
+	}
+	return pkg
+}
@@ -1,6 +1,7 @@
 package loader
 
 import (
+	"context"
 	"fmt"
 	"go/ast"
 	"go/constant"
@@ -8,19 +9,23 @@ import (
 	"go/scanner"
 	"go/token"
 	"go/types"
-	"html/template"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/linker"
 	"github.com/gunk/gunk/assets"
+	"github.com/gunk/gunk/diagnostic"
 	"github.com/gunk/gunk/log"
 	"golang.org/x/tools/go/packages"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/types/descriptorpb"
 )
 
@@ -31,13 +36,29 @@ type Loader struct {
 	// transitive dependencies, including gunk tags. Otherwise, we only
 	// parse the given packages.
 	Types bool
-	cache map[string]*GunkPackage // map from import path to pkg
+	// Overlay holds the contents of files by absolute path, to be used
+	// instead of the files on disk. Gunk files in the overlay are part of
+	// the package in their directory, even if they don't exist on disk.
+	Overlay map[string][]byte
+	// Logger logs the commands run to load the packages. If nil, nothing
+	// is logged.
+	Logger *log.Logger
+	// Diagnostics accumulates the errors of the loaded packages in
+	// ReportErrors. If nil, they are printed as text instead.
+	Diagnostics *diagnostic.Printer
+	cache       map[string]*GunkPackage   // map from import path to pkg
+	goCache     map[string]*types.Package // map from import path to Go pkg
 
 	stack []string
 
 	// fakeFiles is a list of fake Go files added to make the Go compiler pick
 	// up gunk files in packages without Go files.
 	fakeFiles map[string][]byte
+	// optDir is the directory of the github.com/gunk/opt module, if
+	// required, and bundled holds the Gunk files of assets.Opt added to its
+	// packages, by absolute path. See addBundledFiles.
+	optDir  string
+	bundled map[string][]byte
 }
 
 // addFakeFiles iterate over all module dependencies of the specified directory
@@ -49,13 +70,32 @@ func (l *Loader) addFakeFiles() error {
 	l.fakeFiles = make(map[string][]byte)
 	// use "." if we encountered an error, for e.g. GOPATH mode
 	roots := []string{"."}
-	cmd := exec.Command("go", "list", "-m", "-f={{.Dir}}", "all")
+	cmd := l.Logger.CommandContext(context.Background(), "go", "list", "-m", "-f={{.Path}} {{.Dir}}", "all")
 	cmd.Dir = l.Dir
-	if out, err := cmd.Output(); err == nil {
+	if out, err := l.Logger.Output(cmd); err == nil {
 		rootOutput := strings.Split(strings.TrimSpace(string(out)), "\n")
 		roots = make([]string, 0, len(rootOutput))
 		for _, v := range rootOutput {
-			roots = append(roots, strings.TrimSpace(v))
+			// Module paths can't contain spaces.
+			path, dir, _ := strings.Cut(strings.TrimSpace(v), " ")
+			if path == optModule && dir == "" {
+				// The bundled files are added to the packages of
+				// the module, so it has to be on disk first.
+				dir = l.downloadModule(path)
+			}
+			if dir == "" {
+				// Not downloaded yet.
+				continue
+			}
+			if path == optModule {
+				l.optDir = dir
+			}
+			roots = append(roots, dir)
+		}
+	}
+	if l.optDir != "" {
+		if err := l.addBundledFiles(l.optDir); err != nil {
+			return err
 		}
 	}
 	// Walk through all directories and add fake files for all packages that
@@ -68,44 +108,84 @@ func (l *Loader) addFakeFiles() error {
 			if !info.IsDir() {
 				return nil
 			}
-			infos, err := ioutil.ReadDir(path)
+			src, err := l.fakeFile(path)
 			if err != nil {
 				return err
 			}
-			pkgName := info.Name() // default to the directory basename
-			anyGunk := false
-			for _, info := range infos {
-				name := info.Name()
-				if strings.HasSuffix(name, ".go") {
-					// has Go files; nothing to do
-					return nil
-				}
-				if strings.HasSuffix(name, ".gunk") {
-					f, err := parser.ParseFile(token.NewFileSet(),
-						filepath.Join(path, name), nil, parser.PackageClauseOnly)
-					// Ignore errors, since Gunk packages being
-					// walked but not being loaded might have
-					// invalid syntax.
-					if err == nil {
-						pkgName = f.Name.Name
-					}
-					anyGunk = true
-					break
-				}
-			}
-			if !anyGunk {
-				return nil
+			if src != nil {
+				l.fakeFiles[filepath.Join(path, "gunkpkg.go")] = src
 			}
-			tmpPath := filepath.Join(path, "gunkpkg.go")
-			l.fakeFiles[tmpPath] = []byte(`package ` + pkgName)
 			return nil
 		}); err != nil {
 			return err
 		}
 	}
+	// Gunk files only in the overlay need a fake file too, unless their
+	// directory has Go files.
+	for path := range l.Overlay {
+		dir := filepath.Dir(path)
+		tmpPath := filepath.Join(dir, "gunkpkg.go")
+		if filepath.Ext(path) != ".gunk" || l.fakeFiles[tmpPath] != nil {
+			continue
+		}
+		if goFiles, _ := filepath.Glob(filepath.Join(dir, "*.go")); len(goFiles) > 0 {
+			continue
+		}
+		f, err := l.parseFile(token.NewFileSet(), path, parser.PackageClauseOnly)
+		if err != nil {
+			// As above, ignore the errors until the package
+			// is loaded.
+			f = &ast.File{Name: ast.NewIdent(filepath.Base(dir))}
+		}
+		l.fakeFiles[tmpPath] = []byte(`package ` + f.Name.Name)
+	}
 	return nil
 }
 
+// fakeFile returns the fake Go file for the directory if it only has Gunk
+// files, or nil if it has Go files or no Gunk files.
+func (l *Loader) fakeFile(dir string) ([]byte, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	pkgName := filepath.Base(dir) // default to the directory basename
+	anyGunk := false
+	for _, info := range infos {
+		name := info.Name()
+		if strings.HasSuffix(name, ".go") {
+			// has Go files; nothing to do
+			return nil, nil
+		}
+		if strings.HasSuffix(name, ".gunk") {
+			f, err := l.parseFile(token.NewFileSet(),
+				filepath.Join(dir, name), parser.PackageClauseOnly)
+			// Ignore errors, since Gunk packages being walked but
+			// not being loaded might have invalid syntax.
+			if err == nil {
+				pkgName = f.Name.Name
+			}
+			anyGunk = true
+			break
+		}
+	}
+	if !anyGunk {
+		return nil, nil
+	}
+	return []byte(`package ` + pkgName), nil
+}
+
+// parseFile parses the file at path, from l.Overlay if it's in it.
+func (l *Loader) parseFile(fset *token.FileSet, path string, mode parser.Mode) (*ast.File, error) {
+	if src, ok := l.Overlay[path]; ok {
+		return parser.ParseFile(fset, path, src, mode)
+	}
+	if src, ok := l.bundled[path]; ok {
+		return parser.ParseFile(fset, path, src, mode)
+	}
+	return parser.ParseFile(fset, path, nil, mode)
+}
+
 // Load loads the Gunk packages on the provided patterns from the given dir and
 // using the given fileset.
 //
@@ -122,7 +202,14 @@ func (l *Loader) Load(patterns ...string) ([]*GunkPackage, error) {
 		}
 	}
 	var pkgs []*GunkPackage
+	cached := make(map[*GunkPackage]bool)
 	loadFiles := len(patterns) > 0 && strings.HasSuffix(patterns[0], ".gunk")
+	// Generate fake files if it has not been initialized yet.
+	if !loadFiles && l.fakeFiles == nil {
+		if err := l.addFakeFiles(); err != nil {
+			return nil, err
+		}
+	}
 	if loadFiles {
 		// If we're given a number of files, construct a
 		// packages.Package manually. go/packages will treat foo.gunk as
@@ -135,27 +222,41 @@ func (l *Loader) Load(patterns ...string) ([]*GunkPackage, error) {
 			},
 			GunkFiles: patterns,
 		})
+	} else if pkg := l.bundledPackage(patterns...); pkg != nil {
+		// Packages with only bundled files can't be loaded as Go
+		// packages, as overlays can't add files to the module cache.
+		pkgs = append(pkgs, pkg)
 	} else {
-		// Generate fake files if it has not been initialized yet.
-		if l.fakeFiles == nil {
-			err := l.addFakeFiles()
-			if err != nil {
-				return nil, err
+		// Load the Gunk packages as Go packages.
+		overlay := l.fakeFiles
+		if len(l.Overlay) > 0 {
+			overlay = make(map[string][]byte, len(l.fakeFiles)+len(l.Overlay))
+			for path, src := range l.fakeFiles {
+				overlay[path] = src
+			}
+			for path, src := range l.Overlay {
+				overlay[path] = src
 			}
 		}
-		// Load the Gunk packages as Go packages.
 		cfg := &packages.Config{
 			Dir:     l.Dir,
 			Mode:    packages.NeedName | packages.NeedFiles,
-			Overlay: l.fakeFiles,
+			Overlay: overlay,
 		}
 		lpkgs, err := packages.Load(cfg, patterns...)
 		if err != nil {
 			return nil, err
 		}
 		for _, lpkg := range lpkgs {
+			if pkg := l.cache[lpkg.PkgPath]; pkg != nil {
+				// Loaded by a previous call, and not forgotten
+				// since; see Forget.
+				pkgs = append(pkgs, pkg)
+				cached[pkg] = true
+				continue
+			}
 			pkg := &GunkPackage{Package: *lpkg}
-			findGunkFiles(pkg)
+			findGunkFiles(pkg, l.Overlay, l.bundled)
 			if len(pkg.GunkFiles) == 0 && len(pkg.Errors) == 0 {
 				// Not a Gunk package. Skip.
 				continue
@@ -165,6 +266,9 @@ func (l *Loader) Load(patterns ...string) ([]*GunkPackage, error) {
 	}
 	// Add the Gunk files to each package.
 	for _, pkg := range pkgs {
+		if cached[pkg] {
+			continue
+		}
 		for _, v := range l.stack {
 			if v == pkg.PkgPath {
 				// Add the current package to the stack to demonstrate the import cycle.
@@ -187,6 +291,62 @@ func (l *Loader) Load(patterns ...string) ([]*GunkPackage, error) {
 	return pkgs, nil
 }
 
+// Forget drops the packages of the changed files from the cache of the loader,
+// along with the cached packages importing them, directly or not, so that the
+// next Load or Import parses them again. The other packages stay loaded, which
+// lets a Loader be reused as the files change, such as when watching them.
+func (l *Loader) Forget(changed ...string) {
+	// A Load failing on an import cycle leaves its stack behind.
+	l.stack = l.stack[:0]
+	dirs := make(map[string]bool)
+	for _, path := range changed {
+		if filepath.Ext(path) != ".gunk" {
+			continue
+		}
+		dir := filepath.Dir(path)
+		dirs[dir] = true
+		if l.fakeFiles == nil {
+			continue
+		}
+		// The change may add or remove a package with only Gunk
+		// files.
+		tmpPath := filepath.Join(dir, "gunkpkg.go")
+		if src, _ := l.fakeFile(dir); src != nil {
+			l.fakeFiles[tmpPath] = src
+		} else {
+			delete(l.fakeFiles, tmpPath)
+		}
+	}
+	forgotten := make(map[string]bool)
+	for path, pkg := range l.cache {
+		if dirs[pkg.Dir] {
+			forgotten[path] = true
+		}
+	}
+	for more := len(forgotten) > 0; more; {
+		// Keep forgetting the importers of forgotten packages, until
+		// there are no more.
+		more = false
+		for path, pkg := range l.cache {
+			if forgotten[path] {
+				continue
+			}
+			for _, file := range pkg.GunkSyntax {
+				for _, spec := range file.Imports {
+					ipath, _ := strconv.Unquote(spec.Path.Value)
+					if forgotten[ipath] {
+						forgotten[path] = true
+						more = true
+					}
+				}
+			}
+		}
+	}
+	for path := range forgotten {
+		delete(l.cache, path)
+	}
+}
+
 // findGunkFiles fills a package's GunkFiles field with the gunk files found in
 // the package directory. This is used when loading a Gunk package via an import
 // path or a directory.
@@ -194,7 +354,9 @@ func (l *Loader) Load(patterns ...string) ([]*GunkPackage, error) {
 // Note that this requires all the source files within the package to be in the
 // same directory, which is true for Go Modules and GOPATH, but not other build
 // systems like Bazel.
-func findGunkFiles(pkg *GunkPackage) {
+//
+// Gunk files in the overlays which aren't on disk are added too.
+func findGunkFiles(pkg *GunkPackage, overlays ...map[string][]byte) {
 	for _, gofile := range pkg.GoFiles {
 		dir := filepath.Dir(gofile)
 		if pkg.Dir == "" {
@@ -210,6 +372,21 @@ func findGunkFiles(pkg *GunkPackage) {
 		// can only be a malformed pattern; should never happen.
 		panic(err.Error())
 	}
+	added := false
+	for _, overlay := range overlays {
+		for path := range overlay {
+			if filepath.Dir(path) != pkg.Dir || filepath.Ext(path) != ".gunk" {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				matches = append(matches, path)
+				added = true
+			}
+		}
+	}
+	if added {
+		sort.Strings(matches)
+	}
 	pkg.GunkFiles = matches
 }
 
@@ -232,6 +409,9 @@ const (
 // source.
 func (l *Loader) Import(path string) (*types.Package, error) {
 	if !strings.Contains(path, ".") {
+		if pkg := l.goCache[path]; pkg != nil {
+			return pkg, nil
+		}
 		cfg := &packages.Config{Mode: packages.LoadTypes}
 		pkgs, err := packages.Load(cfg, path)
 		if err != nil {
@@ -240,6 +420,10 @@ func (l *Loader) Import(path string) (*types.Package, error) {
 		if len(pkgs) != 1 {
 			panic("expected go/packages.Load to return exactly one package")
 		}
+		if l.goCache == nil {
+			l.goCache = make(map[string]*types.Package)
+		}
+		l.goCache[path] = pkgs[0].Types
 		return pkgs[0].Types, nil
 	}
 	pkgs, err := l.Load(path)
@@ -298,6 +482,18 @@ func (g *GunkPackage) addError(kind packages.ErrorKind, tokenPos token.Pos, fset
 		g.Errors = append(g.Errors, pkgErr)
 		return
 	}
+	if list, ok := err.(scanner.ErrorList); ok && pos == "" {
+		// Add the syntax errors with their positions, like type-checking
+		// errors below.
+		for _, e := range list {
+			g.Errors = append(g.Errors, packages.Error{
+				Pos:  e.Pos.String(),
+				Msg:  e.Msg,
+				Kind: kind,
+			})
+		}
+		return
+	}
 	// Create a packages.Error to add.
 	msg := err.Error()
 	if typeErr, ok := err.(types.Error); ok {
@@ -329,11 +525,12 @@ func (l *Loader) parseGunkPackage(pkg *GunkPackage) {
 	pkg.Name = ""
 	// parse the gunk files
 	for _, fpath := range pkg.GunkFiles {
-		file, err := parser.ParseFile(l.Fset, fpath, nil, parser.ParseComments)
+		file, err := l.parseFile(l.Fset, fpath, parser.ParseComments)
 		if err != nil {
 			pkg.addError(ParseError, 0, nil, err)
 			continue
 		}
+		normalizeDirectives(file)
 		// to make the generated code independent of the current
 		// directory when running gunk
 		relPath := pkg.PkgPath + "/" + filepath.Base(fpath)
@@ -407,6 +604,29 @@ func (l *Loader) parseGunkPackage(pkg *GunkPackage) {
 // validatePackage sanity checks a gunk package, to find common errors which are
 // shared among all gunk commands.
 func (l *Loader) validatePackage(pkg *GunkPackage) {
+	// Find the numbers and names reserved by each message.
+	reserved := make(map[*ast.StructType]*Reserved)
+	for _, file := range pkg.GunkSyntax {
+		for _, decl := range file.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				tspec := spec.(*ast.TypeSpec)
+				st, ok := tspec.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				r, err := DeclReserved(l.Fset, pkg, file, gd, tspec)
+				if err != nil {
+					pkg.errorf(ValidateError, tspec.Pos(), l.Fset, "invalid reserved annotation on %s: %w", tspec.Name.Name, err)
+					continue
+				}
+				reserved[st] = r
+			}
+		}
+	}
 	for _, file := range pkg.GunkSyntax {
 		ast.Inspect(file, func(node ast.Node) bool {
 			st, ok := node.(*ast.StructType)
@@ -431,6 +651,10 @@ func (l *Loader) validatePackage(pkg *GunkPackage) {
 					continue
 				}
 				fieldName := f.Names[0].Name
+				if reserved[st].HasName(fieldName) {
+					pkg.errorf(ValidateError, st.Pos(), l.Fset, "field name %s is reserved", fieldName)
+					continue
+				}
 				str, _ := strconv.Unquote(f.Tag.Value)
 				if err := validateStructTag(str); err != nil {
 					pkg.errorf(ValidateError, st.Pos(), l.Fset, "error in struct tag on %s: %w", fieldName, err)
@@ -458,6 +682,10 @@ func (l *Loader) validatePackage(pkg *GunkPackage) {
 					pkg.errorf(ValidateError, st.Pos(), l.Fset, "unable to convert tag to number on %s: %w", fieldName, err)
 					continue
 				}
+				if reserved[st].HasNumber(sequence) {
+					pkg.errorf(ValidateError, st.Pos(), l.Fset, "sequence %q on %s is reserved", val, fieldName)
+					continue
+				}
 				if usedSequences[sequence] {
 					pkg.errorf(ValidateError, st.Pos(), l.Fset, "sequence %q on %s has already been used in this struct", val, fieldName)
 					continue
@@ -498,99 +726,49 @@ type ProtoLoader struct {
 	// Dir is the absolute path from where the LoadProto method
 	// will load proto files.
 	// If empty, it will load from executing directory
-	Dir        string
-	ProtocPath string
+	Dir string
+}
+
+// bundledProtos are the proto files bundled with Gunk, by import path, along
+// with the asset holding their FileDescriptorSet.
+var bundledProtos = map[string]string{
+	"google/api/annotations.proto":                   "google_api_annotations.fdp",
+	"google/protobuf/empty.proto":                    "google_protobuf_empty.fdp",
+	"google/protobuf/timestamp.proto":                "google_protobuf_timestamp.fdp",
+	"google/protobuf/duration.proto":                 "google_protobuf_duration.fdp",
+	"google/protobuf/struct.proto":                   "google_protobuf_struct.fdp",
+	"google/protobuf/any.proto":                      "google_protobuf_any.fdp",
+	"google/protobuf/field_mask.proto":               "google_protobuf_field_mask.fdp",
+	"google/protobuf/wrappers.proto":                 "google_protobuf_wrappers.fdp",
+	"protoc-gen-openapiv2/options/annotations.proto": "protoc-gen-openapiv2_options_annotations.fdp",
+	"xo/xo.proto":                                    "xo_xo.fdp",
 }
 
 // LoadProto loads the specified protobuf packages as if they were dependencies.
 //
-// It does so with protoc, to leverage protoc's features such as locating the
-// files, and the protoc parser to get a FileDescriptorProto out of the proto
-// file content.
+// The libraries bundled with Gunk are loaded from their generated descriptors.
+// Any other files are parsed and linked in-process, resolving imports against
+// Dir, with the same result as protoc --include_imports: the files come after
+// the files they import.
 func (l *ProtoLoader) LoadProto(names ...string) ([]*descriptorpb.FileDescriptorProto, error) {
-	tmpl := template.Must(template.New("letter").Parse(`
-syntax = "proto3";
-{{range $_, $name := .}}import "{{$name}}";
-{{end}}
-`))
 	// Imports to load from in-memory
 	generatedFilesToLoad := []string{}
-	// Imports to load using protoc
+	// Imports to parse from disk
 	filteredNames := make([]string, 0, len(names))
-	// Check to see if we are trying to load any libraries that we have
-	// bundled with Gunk. If so, load the generated libraries. If not, use
-	// protoc to load those libraries from disk.
 	for _, n := range names {
-		switch n {
-		case "google/api/annotations.proto":
-			generatedFilesToLoad = append(generatedFilesToLoad, "google_api_annotations.fdp")
-		case "google/protobuf/empty.proto":
-			generatedFilesToLoad = append(generatedFilesToLoad, "google_protobuf_empty.fdp")
-		case "google/protobuf/timestamp.proto":
-			generatedFilesToLoad = append(generatedFilesToLoad, "google_protobuf_timestamp.fdp")
-		case "google/protobuf/duration.proto":
-			generatedFilesToLoad = append(generatedFilesToLoad, "google_protobuf_duration.fdp")
-		case "google/protobuf/struct.proto":
-			generatedFilesToLoad = append(generatedFilesToLoad, "google_protobuf_struct.fdp")
-		case "protoc-gen-openapiv2/options/annotations.proto":
-			generatedFilesToLoad = append(generatedFilesToLoad, "protoc-gen-openapiv2_options_annotations.fdp")
-		case "xo/xo.proto":
-			generatedFilesToLoad = append(generatedFilesToLoad, "xo_xo.fdp")
-		default:
+		if asset, ok := bundledProtos[n]; ok {
+			generatedFilesToLoad = append(generatedFilesToLoad, asset)
+		} else {
 			filteredNames = append(filteredNames, n)
 		}
 	}
 	var combinedFset descriptorpb.FileDescriptorSet
-	// Use protoc to load any imports that aren't currently bundles with
-	// Gunk.
 	if len(filteredNames) > 0 {
-		gunkProtoFile := "gunk-proto"
-		if l.Dir != "" {
-			gunkProtoFile = filepath.Join(l.Dir, gunkProtoFile)
-		}
-		importsFile, err := os.Create(gunkProtoFile)
+		files, err := l.compileProto(filteredNames)
 		if err != nil {
 			return nil, err
 		}
-		if err := tmpl.Execute(importsFile, filteredNames); err != nil {
-			return nil, err
-		}
-		if err := importsFile.Close(); err != nil {
-			return nil, err
-		}
-		defer os.Remove(gunkProtoFile)
-		// TODO(mvdan): any way to specify stdout while being portable?
-		// See https://github.com/protocolbuffers/protobuf/issues/4163.
-		args := []string{
-			"-o/dev/stdout",
-			"--include_imports",
-			gunkProtoFile,
-		}
-		if l.Dir != "" {
-			args = append(args, "-I"+l.Dir)
-		}
-		protocPath := "protoc"
-		if l.ProtocPath != "" {
-			protocPath = l.ProtocPath
-		}
-		cmd := log.ExecCommand(protocPath, args...)
-		out, err := cmd.Output()
-		if err != nil {
-			if e, ok := err.(*exec.ExitError); ok {
-				return nil, fmt.Errorf("protoc %s: %s", e, e.Stderr)
-			}
-			return nil, err
-		}
-		var fset descriptorpb.FileDescriptorSet
-		if err := proto.Unmarshal(out, &fset); err != nil {
-			return nil, err
-		}
-		for i := 0; i < len(fset.File); i++ {
-			if *fset.File[i].Name != "gunk-proto" {
-				continue
-			}
-			combinedFset.File = append(fset.File[:i], fset.File[i+1:]...)
-		}
+		combinedFset.File = files
 	}
 	// Load any bundled libraries.
 	for _, fileToLoad := range generatedFilesToLoad {
@@ -607,6 +785,47 @@ syntax = "proto3";
 	return combinedFset.File, nil
 }
 
+// compileProto parses and links the named proto files found in l.Dir, and
+// returns them along with all the files they import. The standard protobuf
+// files, such as google/protobuf/descriptor.proto, don't need to be on disk.
+func (l *ProtoLoader) compileProto(names []string) ([]*descriptorpb.FileDescriptorProto, error) {
+	resolver := &protocompile.SourceResolver{}
+	if l.Dir != "" {
+		resolver.ImportPaths = []string{l.Dir}
+	}
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(resolver),
+	}
+	compiled, err := compiler.Compile(context.Background(), names...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load proto files: %w", err)
+	}
+	var files []*descriptorpb.FileDescriptorProto
+	added := make(map[string]bool)
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if added[fd.Path()] {
+			return
+		}
+		added[fd.Path()] = true
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		if res, ok := fd.(linker.Result); ok {
+			// Keep the descriptor as parsed, which has the same
+			// fields set as the one produced by protoc.
+			files = append(files, proto.Clone(res.FileDescriptorProto()).(*descriptorpb.FileDescriptorProto))
+			return
+		}
+		files = append(files, protodesc.ToFileDescriptorProto(fd))
+	}
+	for _, fd := range compiled {
+		add(fd)
+	}
+	return files, nil
+}
+
 // splitGunkTags parses and typechecks gunk tags from the comments in a Gunk
 // file, adding them to pkg.GunkTags and removing the source lines from each
 // comment.
@@ -639,7 +858,16 @@ func (l *Loader) splitGunkTags(pkg *GunkPackage, file *ast.File) {
 				pkg.GunkTags = make(map[ast.Node][]GunkTag)
 			}
 			pkg.GunkTags[node] = exprs
+			var directives []*ast.Comment
+			for _, c := range (*doc).List {
+				if isNolintDirective(c.Text) {
+					directives = append(directives, c)
+				}
+			}
 			**doc = *CommentFromText(*doc, docText)
+			// Keep the nolint directives, which aren't part of the
+			// documentation, for gunk lint.
+			(*doc).List = append((*doc).List, directives...)
 		}
 		return true
 	})
@@ -654,6 +882,35 @@ func (l *Loader) splitGunkTags(pkg *GunkPackage, file *ast.File) {
 	}
 }
 
+// nolintPrefix is the prefix of the nolint directives of gunk lint, such as
+// "//gunk:nolint:json".
+const nolintPrefix = "//gunk:nolint"
+
+// normalizeDirectives rewrites the nolint directives written with a space
+// after the slashes, such as "// gunk:nolint:json", to the syntax of Go
+// directives. This way, ast.CommentGroup.Text strips them like any other
+// directive, and they don't end up in the documentation of the declarations
+// nor in the generated comments.
+func normalizeDirectives(file *ast.File) {
+	for _, cg := range file.Comments {
+		for _, c := range cg.List {
+			if rest := strings.TrimPrefix(c.Text, "// gunk:nolint"); rest != c.Text && isNolintDirective(nolintPrefix+rest) {
+				c.Text = nolintPrefix + rest
+			}
+		}
+	}
+}
+
+// isNolintDirective returns whether the text of a comment is a nolint
+// directive, written with the syntax of Go directives.
+func isNolintDirective(text string) bool {
+	rest := strings.TrimPrefix(text, nolintPrefix)
+	if rest == text {
+		return false
+	}
+	return rest == "" || rest[0] == ' ' || rest[0] == '\t' || rest[0] == ':'
+}
+
 func nodeDoc(node ast.Node) **ast.CommentGroup {
 	switch node := node.(type) {
 	case *ast.File:
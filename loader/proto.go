@@ -7,6 +7,8 @@ import (
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/scanner"
 	"unicode"
@@ -22,7 +24,7 @@ var urlVarRegexp = regexp.MustCompile(`\{(.*?)\}`)
 // ConvertFromProto converts a single proto file read from r, writing the
 // generated Gunk file to w. The output isn't canonically formatted, so it's up
 // to the caller to use gunk/format.Source on the result if needed.
-func ConvertFromProto(w io.Writer, r io.Reader, filename string, importPath string, protocPath string) error {
+func ConvertFromProto(w io.Writer, r io.Reader, filename string, importPath string) error {
 	// Parse the proto file.
 	parser := proto.NewParser(r)
 	d, err := parser.Parse()
@@ -34,11 +36,11 @@ func ConvertFromProto(w io.Writer, r io.Reader, filename string, importPath stri
 		filename:      filename,
 		importsUsed:   map[string]string{},
 		existingDecls: map[string]bool{},
+		enums:         declaredEnums(d.Elements, ""),
 	}
 	if importPath != "" {
 		b.protoLoader = &ProtoLoader{
-			Dir:        importPath,
-			ProtocPath: protocPath,
+			Dir: importPath,
 		}
 	}
 	for _, e := range d.Elements {
@@ -99,6 +101,13 @@ type builder struct {
 	protoLoader *ProtoLoader
 	// Holds existings declaration to avoid duplicate
 	existingDecls map[string]bool
+	// Holds the enums declared in the file by Gunk name, and in the
+	// imported files by name qualified with their proto package, as
+	// they are referenced by the field types, to tell them apart from
+	// messages.
+	enums map[string]bool
+	// The syntax of the proto file, such as "proto3".
+	syntax string
 }
 
 // format will write output to a string builder, adding in indentation
@@ -153,34 +162,109 @@ func (b *builder) goType(fieldType string) string {
 		return "float32"
 	case "int32":
 		return "int"
-	case "sint32", "sfixed32":
-		return "int32"
-	case "int64", "sint64", "sfixed64":
+	case "int64":
 		return "int64"
-	case "uint32", "fixed32":
+	case "uint32":
 		return "uint32"
-	case "uint64", "fixed64":
+	case "uint64":
 		return "uint64"
-	default:
-		// TODO: We return the proto package name unaltered. This
-		// causes issues when a package name is imported or contains
-		// "." or other invalid characters for a package name.
-		// This is either an unrecognised type, or a custom type.
-		return fieldType
+	case "sint32", "sint64", "fixed32", "fixed64", "sfixed32", "sfixed64":
+		// These wire encodings have no Go equivalent, so use the
+		// marker types to keep them, e.g. types.Sint64.
+		pkg := b.addImportUsed("github.com/gunk/opt/types")
+		return pkg + "." + strings.ToUpper(fieldType[:1]) + fieldType[1:]
+	}
+	if wkt, ok := wellKnownGoTypes[strings.TrimPrefix(fieldType, ".")]; ok {
+		pkg := b.addImportUsed(wkt.importPath)
+		return pkg + "." + wkt.name
 	}
+	// TODO: We return the proto package name unaltered. This
+	// causes issues when a package name is imported or contains
+	// "." or other invalid characters for a package name.
+	// This is either an unrecognised type, or a custom type.
+	return fieldType
+}
+
+// isScalar returns whether a proto field type is a scalar type.
+func isScalar(fieldType string) bool {
+	switch fieldType {
+	case "bool", "string", "bytes", "double", "float", "int32", "int64",
+		"uint32", "uint64", "sint32", "sint64", "fixed32", "fixed64",
+		"sfixed32", "sfixed64":
+		return true
+	}
+	return false
+}
+
+// declaredEnums returns the Gunk names of the enums declared in the elements
+// of a proto file or message, which are named in the form Parent_Child when
+// nested. The prefix is the Gunk name of the parent message followed by "_",
+// if any.
+func declaredEnums(elements []proto.Visitee, prefix string) map[string]bool {
+	enums := make(map[string]bool)
+	for _, e := range elements {
+		switch e := e.(type) {
+		case *proto.Enum:
+			enums[prefix+e.Name] = true
+		case *proto.Message:
+			for name := range declaredEnums(e.Elements, prefix+e.Name+"_") {
+				enums[name] = true
+			}
+		}
+	}
+	return enums
+}
+
+// wellKnownProtos are the files of the protobuf well-known types which are
+// converted to Gunk types, and so don't need to be imported.
+var wellKnownProtos = map[string]bool{
+	"google/protobuf/any.proto":        true,
+	"google/protobuf/duration.proto":   true,
+	"google/protobuf/empty.proto":      true,
+	"google/protobuf/field_mask.proto": true,
+	"google/protobuf/struct.proto":     true,
+	"google/protobuf/timestamp.proto":  true,
+	"google/protobuf/wrappers.proto":   true,
+}
+
+// wellKnownGoTypes maps the protobuf well-known types to their Gunk types.
+var wellKnownGoTypes = map[string]struct{ importPath, name string }{
+	"google.protobuf.Timestamp":   {"time", "Time"},
+	"google.protobuf.Duration":    {"time", "Duration"},
+	"google.protobuf.Value":       {"encoding/json", "RawMessage"},
+	"google.protobuf.Any":         {"github.com/gunk/opt/types", "Any"},
+	"google.protobuf.FieldMask":   {"github.com/gunk/opt/types", "FieldMask"},
+	"google.protobuf.Struct":      {"github.com/gunk/opt/types", "Struct"},
+	"google.protobuf.ListValue":   {"github.com/gunk/opt/types", "ListValue"},
+	"google.protobuf.DoubleValue": {"github.com/gunk/opt/types", "DoubleValue"},
+	"google.protobuf.FloatValue":  {"github.com/gunk/opt/types", "FloatValue"},
+	"google.protobuf.Int64Value":  {"github.com/gunk/opt/types", "Int64Value"},
+	"google.protobuf.UInt64Value": {"github.com/gunk/opt/types", "UInt64Value"},
+	"google.protobuf.Int32Value":  {"github.com/gunk/opt/types", "Int32Value"},
+	"google.protobuf.UInt32Value": {"github.com/gunk/opt/types", "UInt32Value"},
+	"google.protobuf.BoolValue":   {"github.com/gunk/opt/types", "BoolValue"},
+	"google.protobuf.StringValue": {"github.com/gunk/opt/types", "StringValue"},
+	"google.protobuf.BytesValue":  {"github.com/gunk/opt/types", "BytesValue"},
 }
 
 func (b *builder) handleProtoType(typ proto.Visitee) error {
 	var err error
 	switch typ := typ.(type) {
-	case *proto.Syntax, *proto.Comment:
-		// Do nothing with syntax or comment
+	case *proto.Syntax:
+		b.syntax = typ.Value
+	case *proto.Comment:
+		// Do nothing with comment
 	case *proto.Package:
 		// This gets translated at the very end because it is used
 		// in conjuction with the option "go_package" when writting
 		// a Gunk package decleration.
 		b.pkg = typ
 	case *proto.Import:
+		if b.protoLoader != nil && wellKnownProtos[typ.Filename] {
+			// The well-known types are converted to their Gunk
+			// types, see goType.
+			break
+		}
 		if b.protoLoader != nil {
 			files, err := b.protoLoader.LoadProto(typ.Filename)
 			if err != nil {
@@ -190,6 +274,9 @@ func (b *builder) handleProtoType(typ proto.Visitee) error {
 			for _, f := range files {
 				if f != nil && f.GetName() == typ.Filename {
 					named = strings.Replace(f.GetPackage(), ".", "_", -1)
+					for _, e := range f.GetEnumType() {
+						b.enums[f.GetPackage()+"."+e.GetName()] = true
+					}
 					if f.GetOptions() != nil && f.GetOptions().GoPackage != nil {
 						source = *f.GetOptions().GoPackage
 					}
@@ -231,6 +318,7 @@ func (b *builder) handleMessageField(w *strings.Builder, field proto.Visitee) er
 		repeated bool
 		comment  *proto.Comment
 		options  []*proto.Option
+		oneof    string
 	)
 	switch field := field.(type) {
 	case *proto.NormalField:
@@ -240,6 +328,22 @@ func (b *builder) handleMessageField(w *strings.Builder, field proto.Visitee) er
 		comment = field.Comment
 		repeated = field.Repeated
 		options = field.Options
+		// Proto3 optional scalars and enums are represented as
+		// pointers, while message fields always track presence.
+		if field.Optional && b.syntax == "proto3" && (isScalar(field.Type) || b.enums[strings.TrimPrefix(field.Type, ".")]) {
+			typ = "*" + typ
+		}
+	case *proto.OneOfField:
+		name = field.Name
+		typ = b.goType(field.Type)
+		sequence = field.Sequence
+		comment = field.Comment
+		options = field.Options
+		parent, ok := field.Parent.(*proto.Oneof)
+		if !ok {
+			return fmt.Errorf("oneof field %s has no parent oneof", field.Name)
+		}
+		oneof = parent.Name
 	case *proto.MapField:
 		name = field.Field.Name
 		sequence = field.Field.Sequence
@@ -257,6 +361,12 @@ func (b *builder) handleMessageField(w *strings.Builder, field proto.Visitee) er
 	if repeated {
 		typ = "[]" + typ
 	}
+	// Write the comment before any gunk annotations, as otherwise the comment
+	// would become part of the last annotation.
+	if comment != nil && (len(options) > 0 || oneof != "") {
+		b.format(w, 1, comment, "//\n")
+		comment = nil
+	}
 	for _, o := range options {
 		val := o.Constant.Source
 		var impt string
@@ -281,6 +391,10 @@ func (b *builder) handleMessageField(w *strings.Builder, field proto.Visitee) er
 		pkg := b.addImportUsed(impt)
 		b.format(w, 1, nil, fmt.Sprintf("// +gunk %s.%s\n", pkg, value))
 	}
+	if oneof != "" {
+		pkg := b.addImportUsed("github.com/gunk/opt/oneof")
+		b.format(w, 1, nil, "// +gunk %s.%s\n", pkg, b.genAnnotationString("Group", oneof))
+	}
 	// TODO(vishen): Is this correct to explicitly camelcase the variable name and
 	// snakecase the json name???
 	// If we do, gunk should probably have an option to set the variable name
@@ -308,37 +422,56 @@ func (b *builder) handleMessage(m *proto.Message) error {
 		return b.formatError(m.Position, "%s redeclared in this block", m.Name)
 	}
 	b.existingDecls[m.Name] = true
-	b.format(w, 0, m.Comment, "type %s struct {\n", m.Name)
+	// Handle the nested messages and enums first, so that the fields
+	// referring to them can be renamed. They are created at the top level
+	// and renamed in the form Parent_Child, and annotated as nested in
+	// their parent to keep their fully qualified proto names.
 	for _, e := range m.Elements {
 		switch e := e.(type) {
-		case *proto.NormalField:
-			// Check if the type must be renamed in case
-			// of declaration of nested message
-			newType := fmt.Sprintf("%s_%s", m.Name, e.Type)
-			if _, ok := b.existingDecls[newType]; ok {
-				e.Type = newType
+		case *proto.Enum:
+			e.Name = fmt.Sprintf("%s_%s", m.Name, e.Name)
+			if _, ok := b.existingDecls[e.Name]; ok {
+				return b.formatError(e.Position, "%s redeclared in this block", e.Name)
 			}
-			if strings.Contains(e.Type, ".") {
-				ref := strings.Split(e.Type, ".")[0]
-				if !b.containsImport(ref) {
-					tmp := strings.Replace(e.Type, ".", "_", -1)
-					// the type is neither found in import and existing decls
-					if _, ok := b.existingDecls[tmp]; !ok {
-						return b.formatError(e.Position, "%s is undefined", e.Type)
-					}
-					// Handle the use of nested field referenced outside
-					// of its parent; Parent.Type is renamed to Parent_Type in a Go-Derived way
-					e.Type = tmp
-				}
+			b.existingDecls[e.Name] = true
+			if err := b.handleEnum(e); err != nil {
+				return b.formatError(e.Position, "error with nested enum %v", err)
+			}
+		case *proto.Message:
+			e.Name = fmt.Sprintf("%s_%s", m.Name, e.Name)
+			if err := b.handleMessage(e); err != nil {
+				return b.formatError(e.Position, "error with nested message %v", err)
+			}
+		}
+	}
+	var annotations []string
+	if parent, ok := m.Parent.(*proto.Message); ok {
+		pkg := b.addImportUsed("github.com/gunk/opt/message")
+		annotations = append(annotations, pkg+"."+b.genAnnotationString("NestedIn", parent.Name))
+	}
+	if reserved := reservedElements(m.Elements); len(reserved) > 0 {
+		pkg := b.addImportUsed("github.com/gunk/opt/message")
+		annotations = append(annotations, b.genReserved(pkg, reserved, maxFieldNumber))
+	}
+	b.formatAnnotations(w, m.Comment, annotations)
+	b.format(w, 0, nil, "type %s struct {\n", m.Name)
+	for _, e := range m.Elements {
+		switch e := e.(type) {
+		case *proto.Reserved:
+			// Already handled above.
+		case *proto.NormalField:
+			if err := b.resolveFieldType(m, e.Field); err != nil {
+				return err
 			}
 			if err := b.handleMessageField(w, e); err != nil {
 				return b.formatError(e.Position, "error with message field: %v", err)
 			}
-		case *proto.Enum:
-			// Handle the nested enum. This will create a new
-			// top level enum as Gunk doesn't currently support
-			// nested data structures.
-			b.handleEnum(e)
+		case *proto.Oneof:
+			if err := b.handleOneof(w, m, e); err != nil {
+				return err
+			}
+		case *proto.Enum, *proto.Message:
+			// Already handled above.
 		case *proto.Comment:
 			b.format(w, 1, e, "")
 		case *proto.MapField:
@@ -349,13 +482,6 @@ func (b *builder) handleMessage(m *proto.Message) error {
 			if err := b.handleOption(w, e); err != nil {
 				return b.formatError(e.Position, "error with option field: %v", err)
 			}
-		case *proto.Message:
-			// Handle the nested message. The struct is created at
-			// the top level and renamed in the form Parent_Child
-			e.Name = fmt.Sprintf("%s_%s", m.Name, e.Name)
-			if err := b.handleMessage(e); err != nil {
-				return b.formatError(e.Position, "error with nested message %v", err)
-			}
 		default:
 			return b.formatError(m.Position, "unexpected type %T in message", e)
 		}
@@ -365,6 +491,60 @@ func (b *builder) handleMessage(m *proto.Message) error {
 	return nil
 }
 
+// resolveFieldType renames the type of a field declared in message m if it
+// refers to a nested message, as nested messages are converted to top-level
+// structs named in the form Parent_Child.
+func (b *builder) resolveFieldType(m *proto.Message, f *proto.Field) error {
+	if _, ok := wellKnownGoTypes[strings.TrimPrefix(f.Type, ".")]; ok {
+		// Converted in goType.
+		return nil
+	}
+	// Check if the type must be renamed in case
+	// of declaration of nested message
+	newType := fmt.Sprintf("%s_%s", m.Name, f.Type)
+	if _, ok := b.existingDecls[newType]; ok {
+		f.Type = newType
+	}
+	if strings.Contains(f.Type, ".") {
+		ref := strings.Split(f.Type, ".")[0]
+		if !b.containsImport(ref) {
+			tmp := strings.Replace(f.Type, ".", "_", -1)
+			// the type is neither found in import and existing decls
+			if _, ok := b.existingDecls[tmp]; !ok {
+				return b.formatError(f.Position, "%s is undefined", f.Type)
+			}
+			// Handle the use of nested field referenced outside
+			// of its parent; Parent.Type is renamed to Parent_Type in a Go-Derived way
+			f.Type = tmp
+		}
+	}
+	return nil
+}
+
+// handleOneof will convert the fields of a oneof declared in message m to
+// Gunk. Each field is annotated with the name of the oneof it belongs to.
+func (b *builder) handleOneof(w *strings.Builder, m *proto.Message, o *proto.Oneof) error {
+	b.format(w, 1, o.Comment, "")
+	for _, e := range o.Elements {
+		switch e := e.(type) {
+		case *proto.OneOfField:
+			if err := b.resolveFieldType(m, e.Field); err != nil {
+				return err
+			}
+			if err := b.handleMessageField(w, e); err != nil {
+				return b.formatError(e.Position, "error with oneof field: %v", err)
+			}
+		case *proto.Comment:
+			b.format(w, 1, e, "")
+		case *proto.Option:
+			fmt.Fprintln(os.Stderr, b.formatError(e.Position, "unhandled oneof option %q", e.Name))
+		default:
+			return b.formatError(o.Position, "unexpected type %T in oneof", e)
+		}
+	}
+	return nil
+}
+
 func (b *builder) handleOption(w *strings.Builder, opt *proto.Option) error {
 	switch n := opt.Name; n {
 	case "(grpc.gateway.protoc_gen_swagger.options.openapiv2_schema)":
@@ -391,18 +571,36 @@ func (b *builder) handleOption(w *strings.Builder, opt *proto.Option) error {
 // conversion.
 func (b *builder) handleEnum(e *proto.Enum) error {
 	w := &strings.Builder{}
-	b.format(w, 0, e.Comment, "type %s int\n", e.Name)
+	var annotations []string
+	// localName is the name of the enum without the name of its parent,
+	// if nested.
+	localName := e.Name
+	if parent, ok := e.Parent.(*proto.Message); ok {
+		localName = strings.TrimPrefix(e.Name, parent.Name+"_")
+		pkg := b.addImportUsed("github.com/gunk/opt/enum")
+		annotations = append(annotations, pkg+"."+b.genAnnotationString("NestedIn", parent.Name))
+	}
+	if reserved := reservedElements(e.Elements); len(reserved) > 0 {
+		pkg := b.addImportUsed("github.com/gunk/opt/enum")
+		annotations = append(annotations, b.genReserved(pkg, reserved, maxEnumNumber))
+	}
+	b.formatAnnotations(w, e.Comment, annotations)
+	b.format(w, 0, nil, "type %s int\n", e.Name)
 	b.format(w, 0, nil, "\nconst (\n")
 	// Check to see if we can output the enum using an iota. This is
 	// currently only possible if every enum value is an increment of 1
 	// from the previous enum value.
 	outputIota := true
-	for i, c := range e.Elements {
+	numFields := 0
+	for _, c := range e.Elements {
 		switch c := c.(type) {
 		case *proto.EnumField:
-			if i != c.Integer {
+			if numFields != c.Integer {
 				outputIota = false
 			}
+			numFields++
+		case *proto.Reserved:
+			// Already handled above.
 		case *proto.Option:
 			fmt.Fprintln(os.Stderr, b.formatError(c.Position, "unhandled enum option %q", c.Name))
 		default:
@@ -410,7 +608,8 @@ func (b *builder) handleEnum(e *proto.Enum) error {
 		}
 	}
 	// Now we can output the enum as a const.
-	for i, c := range e.Elements {
+	i := -1
+	for _, c := range e.Elements {
 		ef, ok := c.(*proto.EnumField)
 		if !ok {
 			// We should have caught any errors when checking if we can output as
@@ -418,10 +617,16 @@ func (b *builder) handleEnum(e *proto.Enum) error {
 			// TODO(vishen): handle enum option
 			continue
 		}
+		i++
 		// Check if there is already an existing enum field with this name
 		if ok := b.existingDecls[ef.Name]; ok {
-			// prefix with the enum type name
-			ef.Name = e.Name + "_" + ef.Name
+			// prefix with the enum type name, without its parent's
+			// name if nested, unless that is taken too
+			name := localName + "_" + ef.Name
+			if b.existingDecls[name] {
+				name = e.Name + "_" + ef.Name
+			}
+			ef.Name = name
 		}
 		b.existingDecls[ef.Name] = true
 		for _, e := range ef.Elements {
@@ -552,6 +757,70 @@ func (b *builder) handleService(s *proto.Service) error {
 	return nil
 }
 
+// The maximum field and enum numbers, used for reserved ranges ending with
+// "max".
+const (
+	maxFieldNumber = 536870911
+	maxEnumNumber  = 2147483647
+)
+
+// reservedElements returns the reserved statements of a message or an enum.
+func reservedElements(elems []proto.Visitee) []*proto.Reserved {
+	var reserved []*proto.Reserved
+	for _, e := range elems {
+		if r, ok := e.(*proto.Reserved); ok {
+			reserved = append(reserved, r)
+		}
+	}
+	return reserved
+}
+
+// genReserved generates a single Reserved annotation of the given package
+// from all of the reserved statements of a message or an enum.
+func (b *builder) genReserved(pkg string, reserved []*proto.Reserved, max int) string {
+	var numbers, ranges, names []string
+	for _, r := range reserved {
+		for _, rng := range r.Ranges {
+			to := rng.To
+			if rng.Max {
+				to = max
+			}
+			if rng.From == to {
+				numbers = append(numbers, strconv.Itoa(rng.From))
+				continue
+			}
+			ranges = append(ranges, fmt.Sprintf("{From: %d, To: %d}", rng.From, to))
+		}
+		for _, name := range r.FieldNames {
+			names = append(names, strconv.Quote(name))
+		}
+	}
+	var fields []string
+	if len(numbers) > 0 {
+		fields = append(fields, fmt.Sprintf("Numbers: []int{%s}", strings.Join(numbers, ", ")))
+	}
+	if len(ranges) > 0 {
+		fields = append(fields, fmt.Sprintf("Ranges: []%s.Range{%s}", pkg, strings.Join(ranges, ", ")))
+	}
+	if len(names) > 0 {
+		fields = append(fields, fmt.Sprintf("Names: []string{%s}", strings.Join(names, ", ")))
+	}
+	return fmt.Sprintf("%s.Reserved{%s}", pkg, strings.Join(fields, ", "))
+}
+
+// formatAnnotations writes the comment of a top-level declaration followed by
+// its gunk annotations.
+func (b *builder) formatAnnotations(w *strings.Builder, comment *proto.Comment, annotations []string) {
+	if comment != nil && len(annotations) > 0 {
+		b.format(w, 0, comment, "//\n")
+	} else {
+		b.format(w, 0, comment, "")
+	}
+	for _, a := range annotations {
+		b.format(w, 0, nil, "// +gunk %s\n", a)
+	}
+}
+
 func (b *builder) genAnnotation(name, value string) string {
 	return fmt.Sprintf("%s(%s)", name, value)
 }
@@ -782,8 +1051,14 @@ func (b *builder) handleImports() string {
 	}
 	w := &strings.Builder{}
 	b.format(w, 0, nil, "import (")
-	// Imports that have been used during convert.
-	for i, named := range b.importsUsed {
+	// Imports that have been used during convert, in a stable order.
+	imports := make([]string, 0, len(b.importsUsed))
+	for i := range b.importsUsed {
+		imports = append(imports, i)
+	}
+	sort.Strings(imports)
+	for _, i := range imports {
+		named := b.importsUsed[i]
 		b.format(w, 0, nil, "\n")
 		if named != "" {
 			b.format(w, 1, nil, fmt.Sprintf("%s %q", named, i))
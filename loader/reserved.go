@@ -0,0 +1,279 @@
+package loader
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// Reserved holds the numbers and names reserved in a message or an enum,
+// declared with the message.Reserved or enum.Reserved annotations:
+//
+//	// +gunk message.Reserved{
+//	//         Numbers: []int{3},
+//	//         Ranges:  []message.Range{{From: 5, To: 9}},
+//	//         Names:   []string{"old_name"},
+//	// }
+type Reserved struct {
+	Ranges []ReservedRange
+	Names  []string
+}
+
+// ReservedRange is an inclusive range of reserved numbers.
+type ReservedRange struct {
+	From, To int
+}
+
+// HasNumber reports whether n is a reserved number.
+func (r *Reserved) HasNumber(n int) bool {
+	if r == nil {
+		return false
+	}
+	for _, rng := range r.Ranges {
+		if n >= rng.From && n <= rng.To {
+			return true
+		}
+	}
+	return false
+}
+
+// NextUnreserved returns the first number from n onwards which isn't
+// reserved.
+func (r *Reserved) NextUnreserved(n int) int {
+	if r == nil {
+		return n
+	}
+	for moved := true; moved; {
+		moved = false
+		for _, rng := range r.Ranges {
+			if n >= rng.From && n <= rng.To {
+				n, moved = rng.To+1, true
+			}
+		}
+	}
+	return n
+}
+
+// HasName reports whether name is a reserved name.
+func (r *Reserved) HasName(name string) bool {
+	if r == nil {
+		return false
+	}
+	for _, s := range r.Names {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// reservedTypes are the types of the Reserved annotations.
+var reservedTypes = map[string]bool{
+	"github.com/gunk/opt/message.Reserved": true,
+	"github.com/gunk/opt/enum.Reserved":    true,
+}
+
+// ParseReserved parses a message.Reserved or enum.Reserved gunk tag. The type
+// of the annotation is tag.Type if the tag was type-checked, and is resolved
+// through the imports of file otherwise, so that it can be used without type
+// information. It returns nil if the tag isn't a Reserved annotation.
+func ParseReserved(file *ast.File, tag GunkTag) (*Reserved, error) {
+	lit, ok := tag.Expr.(*ast.CompositeLit)
+	if !ok {
+		return nil, nil
+	}
+	var typeName string
+	if tag.Type != nil {
+		typeName = tag.Type.String()
+	} else {
+		typeName = importedTypeName(file, lit.Type)
+	}
+	if !reservedTypes[typeName] {
+		return nil, nil
+	}
+	r := &Reserved{}
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, fmt.Errorf("fields of Reserved must be keyed")
+		}
+		key, _ := kv.Key.(*ast.Ident)
+		list, ok := kv.Value.(*ast.CompositeLit)
+		if key == nil || !ok {
+			return nil, fmt.Errorf("invalid Reserved field")
+		}
+		switch key.Name {
+		case "Numbers":
+			for _, elt := range list.Elts {
+				n, err := reservedInt(elt)
+				if err != nil {
+					return nil, err
+				}
+				r.Ranges = append(r.Ranges, ReservedRange{From: n, To: n})
+			}
+		case "Ranges":
+			for _, elt := range list.Elts {
+				rng, err := reservedRange(elt)
+				if err != nil {
+					return nil, err
+				}
+				r.Ranges = append(r.Ranges, rng)
+			}
+		case "Names":
+			for _, elt := range list.Elts {
+				bl, ok := elt.(*ast.BasicLit)
+				if !ok || bl.Kind != token.STRING {
+					return nil, fmt.Errorf("reserved name must be a string literal")
+				}
+				name, err := strconv.Unquote(bl.Value)
+				if err != nil {
+					return nil, err
+				}
+				r.Names = append(r.Names, name)
+			}
+		default:
+			return nil, fmt.Errorf("unknown Reserved field %s", key.Name)
+		}
+	}
+	if typeName == "github.com/gunk/opt/message.Reserved" {
+		// Only enum values may be negative.
+		for _, rng := range r.Ranges {
+			if rng.From < 1 {
+				return nil, fmt.Errorf("reserved field number %d must be positive", rng.From)
+			}
+		}
+	}
+	return r, nil
+}
+
+// importedTypeName returns the full name of a type of an imported package,
+// such as "github.com/gunk/opt/message.Reserved" for message.Reserved, or an
+// empty string if expr isn't a type of a package imported by file. Like in Gunk
+// annotations, the name of each package is assumed to be the last element of
+// its path, unless the import is named.
+func importedTypeName(file *ast.File, expr ast.Expr) string {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok || file == nil {
+		return ""
+	}
+	for _, spec := range file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			continue
+		}
+		name := path[strings.LastIndex(path, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if name == x.Name {
+			return path + "." + sel.Sel.Name
+		}
+	}
+	return ""
+}
+
+// reservedRange parses a Range{From: x, To: y} composite literal, with its
+// type possibly elided.
+func reservedRange(expr ast.Expr) (ReservedRange, error) {
+	lit, ok := expr.(*ast.CompositeLit)
+	if !ok {
+		return ReservedRange{}, fmt.Errorf("reserved range must be a Range literal")
+	}
+	var rng ReservedRange
+	for i, elt := range lit.Elts {
+		var err error
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			key, _ := kv.Key.(*ast.Ident)
+			switch {
+			case key != nil && key.Name == "From":
+				rng.From, err = reservedInt(kv.Value)
+			case key != nil && key.Name == "To":
+				rng.To, err = reservedInt(kv.Value)
+			default:
+				err = fmt.Errorf("invalid Range field")
+			}
+		} else {
+			switch i {
+			case 0:
+				rng.From, err = reservedInt(elt)
+			case 1:
+				rng.To, err = reservedInt(elt)
+			default:
+				err = fmt.Errorf("too many values in Range")
+			}
+		}
+		if err != nil {
+			return ReservedRange{}, err
+		}
+	}
+	if rng.To < rng.From {
+		return ReservedRange{}, fmt.Errorf("reserved range %d to %d ends before it starts", rng.From, rng.To)
+	}
+	return rng, nil
+}
+
+// reservedInt parses a reserved number, which may be negative for enums.
+func reservedInt(expr ast.Expr) (int, error) {
+	neg := false
+	if un, ok := expr.(*ast.UnaryExpr); ok && un.Op == token.SUB {
+		neg, expr = true, un.X
+	}
+	bl, ok := expr.(*ast.BasicLit)
+	if !ok || bl.Kind != token.INT {
+		return 0, fmt.Errorf("reserved number must be an integer literal")
+	}
+	n, err := strconv.ParseInt(bl.Value, 0, 32)
+	if err != nil {
+		return 0, err
+	}
+	if neg {
+		n = -n
+	}
+	return int(n), nil
+}
+
+// DeclReserved returns the numbers and names reserved by the Reserved
+// annotation of a type declaration in file, if any. The tags are taken from
+// pkg if they were split from the documentation while type-checking, and
+// parsed from the documentation otherwise. pkg may be nil.
+func DeclReserved(fset *token.FileSet, pkg *GunkPackage, file *ast.File, decl *ast.GenDecl, tspec *ast.TypeSpec) (*Reserved, error) {
+	if pkg != nil && pkg.GunkTags[tspec] != nil {
+		return pkg.TypeReserved(tspec)
+	}
+	doc := tspec.Doc
+	if doc == nil && len(decl.Specs) == 1 {
+		doc = decl.Doc
+	}
+	if doc == nil {
+		return nil, nil
+	}
+	_, tags, err := SplitGunkTag(nil, fset, doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		r, err := ParseReserved(file, tag)
+		if err != nil || r != nil {
+			return r, err
+		}
+	}
+	return nil, nil
+}
+
+// TypeReserved returns the numbers and names reserved by the Reserved
+// annotation of a type declaration, if any.
+func (g *GunkPackage) TypeReserved(tspec *ast.TypeSpec) (*Reserved, error) {
+	for _, tag := range g.GunkTags[tspec] {
+		r, err := ParseReserved(nil, tag)
+		if err != nil || r != nil {
+			return r, err
+		}
+	}
+	return nil, nil
+}
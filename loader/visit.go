@@ -2,9 +2,13 @@ package loader
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"sort"
 	"strings"
+
+	"github.com/gunk/gunk/diagnostic"
+	"golang.org/x/tools/go/packages"
 )
 
 // This file is an almost exact copy of go/packages/visit.go, but changed to
@@ -47,6 +51,11 @@ func Visit(pkgs []*GunkPackage, pre func(*GunkPackage) bool, post func(*GunkPack
 // PrintErrors returns the number of errors present, including import errors
 // which aren't printed.
 func PrintErrors(pkgs []*GunkPackage) int {
+	return FprintErrors(os.Stderr, pkgs)
+}
+
+// FprintErrors is like PrintErrors, but prints to w.
+func FprintErrors(w io.Writer, pkgs []*GunkPackage) int {
 	var n int
 	Visit(pkgs, nil, func(pkg *GunkPackage) {
 		for _, err := range pkg.Errors {
@@ -57,9 +66,47 @@ func PrintErrors(pkgs []*GunkPackage) int {
 			if pkg.errorsPrinted {
 				continue
 			}
-			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(w, err)
 		}
 		pkg.errorsPrinted = true
 	})
 	return n
 }
+
+// ReportErrors is like FprintErrors, but adds the errors to l.Diagnostics
+// instead of printing them to w if it's set.
+func (l *Loader) ReportErrors(w io.Writer, pkgs []*GunkPackage) int {
+	if l.Diagnostics == nil {
+		return FprintErrors(w, pkgs)
+	}
+	var n int
+	Visit(pkgs, nil, func(pkg *GunkPackage) {
+		for _, err := range pkg.Errors {
+			n++
+			if strings.Contains(err.Error(), "error importing package") {
+				continue
+			}
+			if pkg.errorsPrinted {
+				continue
+			}
+			l.Diagnostics.Add(diagnostic.FromPackageError(errorRule(err.Kind), err))
+		}
+		pkg.errorsPrinted = true
+	})
+	return n
+}
+
+// errorRule returns the diagnostic rule of a kind of error.
+func errorRule(kind packages.ErrorKind) string {
+	switch kind {
+	case ListError:
+		return "list"
+	case ParseError:
+		return "parse"
+	case TypeError:
+		return "type"
+	case ValidateError:
+		return "validate"
+	}
+	return "unknown"
+}
@@ -1,45 +1,215 @@
+// Package log implements the logger used by gunk to print its progress and the
+// commands it runs, built on log/slog.
 package log
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
-	"os"
+	"log/slog"
 	"os/exec"
 	"strings"
+	"sync"
+	"time"
 )
 
-var (
-	Out           io.Writer = os.Stderr
-	PrintCommands           = false
-	Verbose                 = false
+// Logger prints messages and the commands run, as records of a slog.Handler.
+// It has no global state, so that several can be used at once by programs
+// using gunk as a library. A nil Logger prints nothing.
+type Logger struct {
+	slog          *slog.Logger
+	printCommands bool
+	verbose       bool
+}
+
+// Options configures a Logger.
+type Options struct {
+	// PrintCommands logs the commands run, and once they exit, their
+	// arguments, duration and exit status.
+	PrintCommands bool
+	// Verbose logs the messages printed with Verbosef, and the standard
+	// error of the commands run.
+	Verbose bool
+}
+
+// New returns a Logger sending its records to h. If h is nil, nothing is
+// logged.
+func New(h slog.Handler, opts Options) *Logger {
+	if h == nil {
+		return nil
+	}
+	return &Logger{
+		slog:          slog.New(h),
+		printCommands: opts.PrintCommands,
+		verbose:       opts.Verbose,
+	}
+}
+
+// The formats supported by NewHandler.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
 )
 
-func Printf(format string, args ...interface{}) {
-	if !strings.HasSuffix(format, "\n") {
-		format += "\n"
+// NewHandler returns a handler writing records to w in the format, which is
+// either FormatText or FormatJSON. The text format only prints the messages,
+// one per line. The JSON format prints a JSON object per record, with all of
+// its attributes, including the debug ones such as the duration and exit
+// status of the commands run.
+func NewHandler(w io.Writer, format string) (slog.Handler, error) {
+	switch format {
+	case FormatText, "":
+		return NewTextHandler(w), nil
+	case FormatJSON:
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q: use %s or %s", format, FormatText, FormatJSON)
+}
+
+// textHandler prints the messages of records at the info level or above.
+type textHandler struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+// NewTextHandler returns a handler printing the message of each record at the
+// info level or above to w, on its own line, as gunk always has.
+func NewTextHandler(w io.Writer) slog.Handler {
+	return textHandler{mu: new(sync.Mutex), w: w}
+}
+
+func (h textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= slog.LevelInfo
+}
+
+func (h textHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, r.Message+"\n")
+	return err
+}
+
+func (h textHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h textHandler) WithGroup(string) slog.Handler      { return h }
+
+func (l *Logger) log(level slog.Level, msg string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.slog.Log(context.Background(), level, msg, args...)
+}
+
+func (l *Logger) Printf(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"))
+}
+
+func (l *Logger) Verbosef(format string, args ...interface{}) {
+	if l != nil && l.verbose {
+		l.Printf(format, args...)
+	}
+}
+
+// ErrorWriter returns a writer which logs each line written to it as an error,
+// such as the errors of the packages which failed to load.
+func (l *Logger) ErrorWriter() io.Writer {
+	return &lineWriter{l: l, level: slog.LevelError}
+}
+
+// lineWriter logs each line written to it as a record.
+type lineWriter struct {
+	l     *Logger
+	level slog.Level
+	attrs []interface{}
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.l.log(w.level, string(w.buf[:i]), w.attrs...)
+		w.buf = w.buf[i+1:]
 	}
-	fmt.Fprintf(Out, format, args...)
+	return len(p), nil
 }
 
-func Verbosef(format string, args ...interface{}) {
-	if Verbose {
-		Printf(format, args...)
+// flush logs what's left after the last line, if anything.
+func (w *lineWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.l.log(w.level, string(w.buf), w.attrs...)
+		w.buf = nil
 	}
 }
 
-func ExecCommand(command string, args ...string) *exec.Cmd {
-	if PrintCommands {
-		Printf(formatCommand(command, args...))
+// CommandContext returns a command like exec.CommandContext, logging it first
+// if PrintCommands is set. The process is killed if ctx is done before it
+// exits. Use Run or Output to also log how it exited.
+func (l *Logger) CommandContext(ctx context.Context, command string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, command, args...)
+	if l != nil && l.printCommands {
+		l.log(slog.LevelInfo, formatCommand(command, args...), "command", command, "args", cmd.Args[1:])
 	}
-	cmd := exec.Command(command, args...)
-	if Verbose {
-		cmd.Stderr = Out
+	if l != nil && l.verbose {
+		cmd.Stderr = &lineWriter{l: l, level: slog.LevelInfo, attrs: []interface{}{"command", command}}
 	}
 	return cmd
 }
 
+// Run runs cmd like cmd.Run, logging how it exited if PrintCommands is set.
+func (l *Logger) Run(cmd *exec.Cmd) error {
+	start := time.Now()
+	err := cmd.Run()
+	l.logExit(cmd, start, err)
+	return err
+}
+
+// Output runs cmd like cmd.Output, logging how it exited if PrintCommands is
+// set.
+func (l *Logger) Output(cmd *exec.Cmd) ([]byte, error) {
+	start := time.Now()
+	out, err := cmd.Output()
+	l.logExit(cmd, start, err)
+	return out, err
+}
+
+// logExit logs the arguments, duration and exit status of cmd at the debug
+// level, so that they're only in the structured formats.
+func (l *Logger) logExit(cmd *exec.Cmd, start time.Time, err error) {
+	if w, ok := cmd.Stderr.(*lineWriter); ok {
+		w.flush()
+	}
+	if l == nil || !l.printCommands {
+		return
+	}
+	attrs := []interface{}{
+		"command", cmd.Args[0],
+		"args", cmd.Args[1:],
+		"duration", time.Since(start),
+	}
+	if cmd.ProcessState != nil {
+		attrs = append(attrs, "exit_status", cmd.ProcessState.ExitCode())
+	}
+	if err != nil {
+		attrs = append(attrs, "error", err.Error())
+	}
+	l.log(slog.LevelDebug, "exited "+cmd.Args[0], attrs...)
+}
+
 // formatCommand formats the command output
 func formatCommand(name string, params ...string) string {
+	if len(params) == 0 {
+		return name
+	}
 	paramstr := " " + strings.Join(params, " ")
 	if (len(paramstr) + len(name)) >= 40 {
 		paramstr = ""
@@ -55,8 +225,8 @@ func formatCommand(name string, params ...string) string {
 func ExecError(command string, err error) error {
 	if xerr, ok := err.(*exec.ExitError); ok && len(xerr.Stderr) > 0 {
 		// If the error contains some stderr, include it.
-		// If we're running in verbose mode, stderr was already written
-		// directly to os.Stderr, so it may not be here.
+		// If we're running in verbose mode, stderr was already logged,
+		// so it may not be here.
 		err = fmt.Errorf("%v: %s", xerr.ProcessState, xerr.Stderr)
 	}
 	return fmt.Errorf("error executing %q: %w", command, err)
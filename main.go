@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"runtime"
 
+	"github.com/gunk/gunk/breaking"
 	"github.com/gunk/gunk/convert"
+	"github.com/gunk/gunk/diagnostic"
 	"github.com/gunk/gunk/dump"
+	"github.com/gunk/gunk/export"
 	"github.com/gunk/gunk/format"
 	"github.com/gunk/gunk/generate"
 	"github.com/gunk/gunk/generate/downloader"
@@ -33,6 +40,24 @@ func run() error {
 	app.SetFlagErrorFunc(func(c *cobra.Command, e error) error {
 		return fmt.Errorf("%v\nRun '%s --help' for usage.", e, c.CommandPath())
 	})
+	// logger is set up from the flags before running any command
+	var logFormat string
+	var logOpts log.Options
+	var logger *log.Logger
+	// diags is set up from the --format flag of the commands reporting
+	// diagnostics, and is nil for the text format
+	diagFormat := diagnostic.FormatText
+	var diags *diagnostic.Printer
+	app.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		h, err := log.NewHandler(os.Stderr, logFormat)
+		if err != nil {
+			return err
+		}
+		logger = log.New(h, logOpts)
+		diags, err = diagnostic.New(os.Stdout, diagFormat)
+		return err
+	}
+	app.PersistentFlags().StringVar(&logFormat, "log-format", log.FormatText, "Format of the logs written to stderr: [text | json]")
 	// versionCmd commmand
 	versionCmd := &cobra.Command{
 		Use:   "version",
@@ -43,16 +68,63 @@ func run() error {
 	}
 	app.AddCommand(versionCmd)
 	// generate command
+	var jobs int
+	var check, watchGenerate bool
+	var output generate.Output
 	generateCmd := &cobra.Command{
 		Use:   "generate [patterns]",
 		Short: "Generate code from Gunk packages",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return generate.Run("", args...)
+			toOutput := output.Dir != "" || output.Archive != ""
+			g := generate.New(generate.Options{Logger: logger, Diagnostics: diags, Jobs: jobs})
+			switch {
+			case diags != nil && watchGenerate:
+				return fmt.Errorf("cannot use --format with --watch")
+			case diags != nil && output.Archive == "-":
+				return fmt.Errorf("cannot use --format with --output-archive=-, as both write to stdout")
+			case check && watchGenerate:
+				return fmt.Errorf("cannot use --check with --watch")
+			case output.Dir != "" && output.Archive != "":
+				return fmt.Errorf("cannot use --output-dir with --output-archive")
+			case toOutput && (check || watchGenerate):
+				return fmt.Errorf("cannot use --output-dir or --output-archive with --check or --watch")
+			case toOutput:
+				output.Stdout = os.Stdout
+				return flushDiagnostics(diags, g.RunOutput(context.Background(), output, args...))
+			case check:
+				// The diagnostics are written to stdout instead of the diff.
+				w := io.Writer(os.Stdout)
+				if diags != nil {
+					w = os.Stderr
+				}
+				return flushDiagnostics(diags, g.Check(context.Background(), w, args...))
+			case watchGenerate:
+				ctx, stop := interruptContext()
+				defer stop()
+				return g.Watch(ctx, args...)
+			}
+			return flushDiagnostics(diags, g.Run(context.Background(), args...))
 		},
 	}
-	generateCmd.Flags().BoolVarP(&log.PrintCommands, "print-commands", "x", false, "Print the commands")
-	generateCmd.Flags().BoolVarP(&log.Verbose, "verbose", "v", false, "Print the names of packages are they are generated")
+	addFormatFlag(generateCmd, &diagFormat)
+	generateCmd.Flags().BoolVarP(&logOpts.PrintCommands, "print-commands", "x", false, "Print the commands")
+	generateCmd.Flags().BoolVarP(&logOpts.Verbose, "verbose", "v", false, "Print the names of packages are they are generated")
+	generateCmd.Flags().BoolVar(&check, "check", false, "Don't write the generated files, but fail if they differ from the files on disk")
+	generateCmd.Flags().BoolVarP(&watchGenerate, "watch", "w", false, "Keep watching the Gunk files, regenerating the packages affected by changes")
+	generateCmd.Flags().IntVarP(&jobs, "jobs", "j", runtime.GOMAXPROCS(0), "Number of generators to run in parallel")
+	generateCmd.Flags().StringVar(&output.Dir, "output-dir", "", "Write the generated files to this directory instead of the source tree")
+	generateCmd.Flags().StringVar(&output.Archive, "output-archive", "", "Write the generated files to this .zip, .tar, .tar.gz or .tgz archive instead of the source tree, or to stdout as a tar archive if \"-\"")
 	app.AddCommand(generateCmd)
+	// clean command
+	cleanCmd := &cobra.Command{
+		Use:   "clean [patterns]",
+		Short: "Remove the files generated from Gunk packages",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return generate.New(generate.Options{Logger: logger}).Clean(args...)
+		},
+	}
+	cleanCmd.Flags().BoolVarP(&logOpts.Verbose, "verbose", "v", false, "Print the names of files as they are removed")
+	app.AddCommand(cleanCmd)
 	// convert command
 	var overwrite bool
 	convertCmd := &cobra.Command{
@@ -65,13 +137,24 @@ func run() error {
 	convertCmd.Flags().BoolVarP(&overwrite, "overwrite", "w", false, "Overwrite the converted Gunk file if it exists.")
 	app.AddCommand(convertCmd)
 	// format command
+	var watchFormat bool
 	formatCmd := &cobra.Command{
 		Use:   "format [patterns]",
 		Short: "Format Gunk code",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return format.Run("", args...)
+			if watchFormat {
+				if diags != nil {
+					return fmt.Errorf("cannot use --format with --watch")
+				}
+				ctx, stop := interruptContext()
+				defer stop()
+				return format.Watch(ctx, "", logger, args...)
+			}
+			return flushDiagnostics(diags, format.Run("", diags, args...))
 		},
 	}
+	addFormatFlag(formatCmd, &diagFormat)
+	formatCmd.Flags().BoolVarP(&watchFormat, "watch", "w", false, "Keep watching the Gunk files, formatting them as they change")
 	app.AddCommand(formatCmd)
 	// dump command
 	var dumpFormat string
@@ -84,9 +167,44 @@ func run() error {
 	}
 	dump.Flags().StringVarP(&dumpFormat, "format", "f", "proto", "output format: [proto | json]")
 	app.AddCommand(dump)
+	// breaking command
+	var baseline breaking.Baseline
+	var breakingLevel string
+	breakingCmd := cobra.Command{
+		Use:   "breaking [--against file | --against-git ref] [patterns]",
+		Short: "Report breaking changes to a Gunk package since a baseline",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			level, err := breaking.ParseLevel(breakingLevel)
+			if err != nil {
+				return err
+			}
+			return breaking.Run(os.Stdout, "", logger, baseline, level, args...)
+		},
+	}
+	breakingCmd.Flags().StringVar(&baseline.File, "against", "", "File written by gunk dump to compare against")
+	breakingCmd.Flags().StringVar(&baseline.GitRef, "against-git", "", "Git ref to compare against")
+	breakingCmd.Flags().StringVar(&breakingLevel, "level", "WIRE_JSON", "Changes to report: [WIRE | WIRE_JSON | SOURCE]")
+	app.AddCommand(&breakingCmd)
+	// export command
+	exportCmd := cobra.Command{
+		Use:   "export [proto]",
+		Short: "Export Gunk packages to other formats",
+	}
+	// export proto command
+	var exportOutput string
+	exportProtoCmd := cobra.Command{
+		Use:   "proto [-o dir] [patterns]",
+		Short: "Write Gunk packages as .proto source files",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return export.Run("", exportOutput, args...)
+		},
+	}
+	exportProtoCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Directory to write the .proto files to, instead of stdout")
+	exportCmd.AddCommand(&exportProtoCmd)
+	app.AddCommand(&exportCmd)
 	// download list
 	// TODO(hhhapz): add protoc-java, and protoc-ts, etc.
-	downloadSubcommands := []func(string, string) error{
+	downloadSubcommands := []func(*log.Logger, string, string) error{
 		downloadProtoc,
 	}
 	// download command
@@ -94,13 +212,13 @@ func run() error {
 		Use:   "download [protoc | protoc]",
 		Short: "Download the necessary tools for Gunk",
 	}
-	downloadCmd.Flags().BoolVarP(&log.Verbose, "verbose", "v", false, "Print details of downloaded tools")
+	downloadCmd.Flags().BoolVarP(&logOpts.Verbose, "verbose", "v", false, "Print details of downloaded tools")
 	downloadAllCmd := cobra.Command{
 		Use:   "all",
 		Short: "Download all required tools for Gunk, e.g., protoc",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			for _, f := range downloadSubcommands {
-				if err := f("", ""); err != nil {
+				if err := f(logger, "", ""); err != nil {
 					return err
 				}
 			}
@@ -113,11 +231,11 @@ func run() error {
 		Use:   "protoc",
 		Short: "Download protoc",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return downloadProtoc(dlProtocPath, dlProtocVer)
+			return downloadProtoc(logger, dlProtocPath, dlProtocVer)
 		},
 	}
 	downloadProtocCmd.Flags().StringVar(&dlProtocPath, "path", "", "Path to check for protoc binary, or where to download it to")
-	downloadProtocCmd.Flags().BoolVarP(&log.Verbose, "verbose", "v", false, "Print details of download tools")
+	downloadProtocCmd.Flags().BoolVarP(&logOpts.Verbose, "verbose", "v", false, "Print details of download tools")
 	downloadProtocCmd.Flags().StringVar(&dlProtocVer, "version", "", "Version of protoc to use")
 	downloadCmd.AddCommand(&downloadAllCmd, &downloadProtocCmd)
 	app.AddCommand(&downloadCmd)
@@ -130,13 +248,14 @@ func run() error {
 			if len(args) > 0 {
 				path = args[0]
 			}
-			return vetconfig.Run(path)
+			return flushDiagnostics(diags, vetconfig.Run(path, diags))
 		},
 	}
+	addFormatFlag(&vetCmd, &diagFormat)
 	app.AddCommand(&vetCmd)
 	// lint command
 	var enableLint, disableLint string
-	var listLinters bool
+	var listLinters, fixLint bool
 	lintCmd := cobra.Command{
 		Use:   "lint [patterns]",
 		Short: "Lint a set of Gunk files",
@@ -145,17 +264,39 @@ func run() error {
 				lint.PrintLinters()
 				return nil
 			}
-			return lint.Run("", enableLint, disableLint, args...)
+			return flushDiagnostics(diags, lint.Run("", logger, diags, enableLint, disableLint, fixLint, args...))
 		},
 	}
-	lintCmd.Flags().StringVar(&enableLint, "enable", "", "Linters to enable (all if empty) separated by comma")
+	lintCmd.Flags().StringVar(&enableLint, "enable", "", "Linters to enable separated by comma, instead of the ones in .gunkconfig (all if empty)")
 	lintCmd.Flags().StringVar(&disableLint, "disable", "", "Linters to disable separated by comma, overrides enable")
 	lintCmd.Flags().BoolVarP(&listLinters, "list", "l", false, "List all linters and exit")
+	lintCmd.Flags().BoolVar(&fixLint, "fix", false, "Apply the suggested fixes to the Gunk files, and only report the errors which can't be fixed")
+	addFormatFlag(&lintCmd, &diagFormat)
 	app.AddCommand(&lintCmd)
 	return app.Execute()
 }
 
-func downloadProtoc(path, version string) error {
-	_, err := downloader.CheckOrDownloadProtoc(path, version)
+// addFormatFlag adds the --format flag of the commands reporting diagnostics.
+func addFormatFlag(cmd *cobra.Command, format *string) {
+	cmd.Flags().StringVar(format, "format", diagnostic.FormatText, "Format of the diagnostics, written to stdout unless text: [text | json | sarif | checkstyle]")
+}
+
+// flushDiagnostics writes the diagnostics reported by a command, which
+// returned err.
+func flushDiagnostics(diags *diagnostic.Printer, err error) error {
+	if ferr := diags.Flush(); ferr != nil && err == nil {
+		err = fmt.Errorf("unable to write diagnostics: %w", ferr)
+	}
+	return err
+}
+
+// interruptContext returns a context which is done on an interrupt, to stop
+// commands that watch for changes.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+func downloadProtoc(logger *log.Logger, path, version string) error {
+	_, err := downloader.CheckOrDownloadProtoc(logger, path, version)
 	return err
 }
@@ -8,10 +8,13 @@ import (
 	"strings"
 
 	"github.com/gunk/gunk/config"
+	"github.com/gunk/gunk/diagnostic"
 	"github.com/gunk/gunk/generate/downloader"
 )
 
-func Run(dir string) error {
+// Run vets the .gunkconfig files under dir. The issues found are added to
+// diags, or printed to stdout if it's nil.
+func Run(dir string, diags *diagnostic.Printer) error {
 	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -29,26 +32,39 @@ func Run(dir string) error {
 			if err != nil {
 				return fmt.Errorf("unable to load gunkconfig: %w", err)
 			}
-			vetCfg(path, cfg)
+			for _, d := range vetCfg(path, cfg) {
+				if diags == nil {
+					fmt.Println(d)
+					continue
+				}
+				diags.Add(d)
+			}
 		}
 		return nil
 	})
 	return err
 }
 
-func vetCfg(dir string, cfg *config.Config) {
+// vetCfg returns the issues found in the config read from the file at path.
+func vetCfg(path string, cfg *config.Config) []diagnostic.Diagnostic {
+	var diags []diagnostic.Diagnostic
+	add := func(rule, format string, args ...interface{}) {
+		diags = append(diags, diagnostic.Diagnostic{
+			Rule:     rule,
+			Severity: diagnostic.Warning,
+			File:     path,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
 	if cfg.ProtocVersion == "" {
-		fmt.Printf("%s: specify protoc version\n", dir)
+		add("protoc_version", "specify protoc version")
 	}
 
 	for _, g := range cfg.Generators {
 		code := g.Code()
 		if code == "ts" || code == "js" {
 			if !g.FixPaths {
-				fmt.Printf(
-					"%s: add fix_paths_postproc=true [generate %s]\n",
-					dir,
-					code)
+				add("fix_paths", "add fix_paths_postproc=true [generate %s]", code)
 			}
 		}
 		if code == "grpc-gateway" {
@@ -61,25 +77,16 @@ func vetCfg(dir string, cfg *config.Config) {
 					panic(err)
 				}
 				if major < 2 {
-					fmt.Printf(
-						"%s: use new version - plugin_version=v2.3.0 [generate %s]\n",
-						dir,
-						code)
+					add("plugin_version", "use new version - plugin_version=v2.3.0 [generate %s]", code)
 				}
 			}
 		}
 		if code == "swagger" {
-			fmt.Printf(
-				"%s: do not use swagger. [generate %s] Use:\n[generate openapiv2]\njson_names_for_fields=true\nplugin_version=v2.3.0\n\n",
-				dir,
-				code)
+			add("swagger", "do not use swagger. [generate %s] Use:\n[generate openapiv2]\njson_names_for_fields=true\nplugin_version=v2.3.0", code)
 		}
 		if code == "openapiv2" {
 			if _, ok := g.GetParam("json_names_for_fields"); !ok {
-				fmt.Printf(
-					"%s: specify json_names_for_fields=false (or true) [generate %s]\n",
-					dir,
-					code)
+				add("json_names", "specify json_names_for_fields=false (or true) [generate %s]", code)
 			}
 		}
 		if code == "go" {
@@ -90,52 +97,35 @@ func vetCfg(dir string, cfg *config.Config) {
 					minor, err := strconv.Atoi(s[1])
 					if err == nil {
 						if minor < 20 {
-							fmt.Printf(
-								"%s: use new version - plugin_version=e471641 [generate %s]\n",
-								dir,
-								code)
+							add("plugin_version", "use new version - plugin_version=e471641 [generate %s]", code)
 						}
 					}
 				}
 			}
 			if _, ok := g.GetParam("plugins"); ok {
-				fmt.Printf(
-					"%s: do not use grpc plugin. [generate %s] Use:\n[generate grpc-go]\nplugin_version=v1.1.0\n\n",
-					dir,
-					code)
+				add("grpc_plugin", "do not use grpc plugin. [generate %s] Use:\n[generate grpc-go]\nplugin_version=v1.1.0", code)
 			}
 		}
 		if !g.Shortened {
 			if g.ProtocGen != "" {
 				if config.ProtocBuiltinLanguages[g.ProtocGen] {
-					fmt.Printf(
-						"%s: using protoc builtin language, use shortened version [generate %s]\n",
-						dir,
-						g.ProtocGen)
+					add("shortened", "using protoc builtin language, use shortened version [generate %s]", g.ProtocGen)
 				} else {
-					fmt.Printf(
-						"%s: using protoc for external binary. "+
-							"Consider using shortened version  [generate %s]\n",
-						dir,
-						g.ProtocGen)
+					add("shortened", "using protoc for external binary. "+
+						"Consider using shortened version  [generate %s]", g.ProtocGen)
 				}
 			} else {
 				if strings.HasPrefix(g.Command, "protoc-gen-") {
-					fmt.Printf(
-						"%s: using command- where shortened version exists. "+
-							"Use shortened version [generate %s]\n",
-						dir,
-						code)
+					add("shortened", "using command- where shortened version exists. "+
+						"Use shortened version [generate %s]", code)
 				}
 			}
 		}
 		if downloader.Has(code) {
 			if g.PluginVersion == "" {
-				fmt.Printf(
-					"%s: pin version of %s.\n",
-					dir,
-					code)
+				add("pin_version", "pin version of %s.", code)
 			}
 		}
 	}
+	return diags
 }
@@ -0,0 +1,155 @@
+// Package watch implements watching Gunk packages for changes, for commands
+// like gunk generate --watch.
+package watch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gunk/gunk/loader"
+	"github.com/gunk/gunk/log"
+	"github.com/karelbilek/dirchanges"
+)
+
+// Interval is how often the files are checked for changes.
+var Interval = 500 * time.Millisecond
+
+// Run calls fn, and then calls it again every time .gunk or .gunkconfig files
+// under dir change, with the paths of the changed files, until ctx is done.
+// The first call gets no paths. Errors returned by fn are logged, as the
+// files may be fixed by the next change.
+func Run(ctx context.Context, dir string, logger *log.Logger, fn func(changed []string) error) error {
+	if dir == "" {
+		dir = "."
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	w, err := newWatcher(dir)
+	if err != nil {
+		return err
+	}
+	if err := fn(nil); err != nil {
+		logger.Printf("Error: %v", err)
+	}
+	logger.Printf("watching for changes in %s", dir)
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		events, err := w.Diff()
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			continue
+		}
+		// Snapshot the files before calling fn, so that changes made
+		// while it runs are seen on the next tick.
+		if w, err = newWatcher(dir); err != nil {
+			return err
+		}
+		seen := make(map[string]bool)
+		var changed []string
+		for _, ev := range events {
+			for _, path := range []string{ev.Path, ev.OldPath} {
+				if path != "" && !seen[path] {
+					seen[path] = true
+					changed = append(changed, path)
+				}
+			}
+		}
+		sort.Strings(changed)
+		if err := fn(changed); err != nil {
+			logger.Printf("Error: %v", err)
+		}
+	}
+}
+
+// newWatcher returns a watcher with a snapshot of the .gunk and .gunkconfig
+// files under dir, skipping hidden directories.
+func newWatcher(dir string) (*dirchanges.Watcher, error) {
+	w := dirchanges.New()
+	w.AddFilterHook(func(info os.FileInfo, path string) error {
+		switch {
+		case info.IsDir() && path != dir && strings.HasPrefix(info.Name(), "."):
+			return filepath.SkipDir
+		case info.IsDir():
+			// Only changes to files are of interest, but keep
+			// looking into the directory.
+			return dirchanges.ErrSkip
+		case filepath.Ext(path) == ".gunk", info.Name() == ".gunkconfig":
+			return nil
+		}
+		return dirchanges.ErrSkip
+	})
+	if err := w.AddRecursive(dir); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Affected loads the Gunk packages matching the patterns with l, and returns
+// the import paths of the ones affected by the changed files: the packages of
+// changed .gunk files, and the packages in or under the directory of a changed
+// .gunkconfig file. If importers is true, the packages importing an affected
+// package, directly or not, are affected too.
+//
+// The packages of the changed files and their importers are first forgotten
+// by l, so that the same loader can be kept across changes, with the packages
+// which didn't change still loaded. The errors in the packages aren't
+// reported, so that they can be when the packages are processed.
+func Affected(l *loader.Loader, patterns, changed []string, importers bool) ([]string, error) {
+	l.Forget(changed...)
+	pkgs, err := l.Load(patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading packages: %w", err)
+	}
+	affected := make(map[string]bool)
+	for _, path := range changed {
+		pdir := filepath.Dir(path)
+		config := filepath.Base(path) == ".gunkconfig"
+		for _, pkg := range pkgs {
+			if pkg.Dir == pdir || (config && strings.HasPrefix(pkg.Dir, pdir+string(filepath.Separator))) {
+				affected[pkg.PkgPath] = true
+			}
+		}
+	}
+	for importers {
+		// Keep adding the importers of affected packages, until
+		// there are no more.
+		importers = false
+		for _, pkg := range pkgs {
+			if affected[pkg.PkgPath] {
+				continue
+			}
+			for _, file := range pkg.GunkSyntax {
+				for _, spec := range file.Imports {
+					path, _ := strconv.Unquote(spec.Path.Value)
+					if affected[path] {
+						affected[pkg.PkgPath] = true
+						importers = true
+					}
+				}
+			}
+		}
+	}
+	var paths []string
+	for _, pkg := range pkgs {
+		if affected[pkg.PkgPath] {
+			paths = append(paths, pkg.PkgPath)
+		}
+	}
+	return paths, nil
+}